@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/cloudwindy/xitu/st"
+	"github.com/cloudwindy/xitu/st/embedding"
+	"github.com/rs/zerolog/log"
+)
+
+// configType 是服务端配置文件的结构
+type configType struct {
+	Prompts   promptConfigType   `json:"prompts"`   // 默认提示词
+	Lorebooks lorebookConfigType `json:"lorebooks"` // 独立设定集的附加规则
+	Presets   presetConfigType   `json:"presets"`   // 预设的选择规则
+
+	Models map[string]modelConfigType `json:"models"` // 按上游模型名称设置上下文窗口与分词器，未列出的模型使用 "default"
+}
+
+// promptConfigType 定义角色卡未设置或通过 {{original}} 引用时使用的默认提示词
+type promptConfigType struct {
+	Main                  string           `json:"main"`                    // 默认主提示词
+	PostHistory           string           `json:"post_history"`            // 默认后历史指令
+	Order                 []st.PromptBlock `json:"order"`                   // 提示词顺序 (可选)
+	SystemExampleMessages bool             `json:"system_example_messages"` // 示例对话以带 name 的 system 消息发送 (可选)
+}
+
+// lorebookConfigType 定义独立设定集附加到哪些请求上，值为 lorebooks/ 目录下的设定集名称
+type lorebookConfigType struct {
+	Global     []string            `json:"global"`     // 附加到所有角色
+	Characters map[string][]string `json:"characters"` // 按角色ID附加
+	APIKeys    map[string][]string `json:"api_keys"`   // 按API Key附加
+
+	Budget    int `json:"budget"`     // World Info 的 token 预算，为上下文窗口的百分比 (可选, 0 为不限制)
+	BudgetCap int `json:"budget_cap"` // World Info 的 token 预算上限 (可选, 0 为不限制)
+
+	ScanDepth         int      `json:"scan_depth"`          // 默认扫描深度，条目与设定集均未设置时使用 (可选, 默认 2)
+	MaxRecursionSteps int      `json:"max_recursion_steps"` // 最大递归扫描次数 (可选, 0 为不限制)
+	MatchSources      []string `json:"match_sources"`       // 对所有条目扫描的匹配来源，如 persona, description (可选)
+
+	Vectors *vectorConfigType `json:"vectors"` // 向量化条目的相似度激活 (可选, 未设置时不激活向量化条目)
+}
+
+// vectorConfigType 定义向量化条目使用的向量化方式与激活条件
+type vectorConfigType struct {
+	Embedder   string  `json:"embedder"`    // hashing 或 openai (可选, 默认 hashing)
+	BaseURL    string  `json:"base_url"`    // OpenAI 兼容的接口地址 (可选, 默认为 OPENAI_BASE_URL)
+	APIKey     string  `json:"api_key"`     // 接口的 API Key (可选, 默认为 OPENAI_API_KEY)
+	Model      string  `json:"model"`       // 向量化模型，embedder 为 openai 时必填
+	Threshold  float32 `json:"threshold"`   // 余弦相似度阈值 (可选, 默认 0.25)
+	MaxEntries int     `json:"max_entries"` // 最多激活的条目数 (可选, 0 为不限制)
+	QueryDepth int     `json:"query_depth"` // 作为检索内容的最近消息条数 (可选, 默认 2)
+	IndexSize  int     `json:"index_size"`  // 缓存的条目向量数上限，超出时淘汰最久未使用的 (可选, 默认 10000)
+	Timeout    int     `json:"timeout"`     // 单次向量化的超时秒数 (可选, 默认 10)
+}
+
+// presetConfigType 定义未在请求中指定预设时使用的预设，值为 presets/ 目录下的预设名称
+type presetConfigType struct {
+	Default string            `json:"default"`  // 默认预设 (可选)
+	APIKeys map[string]string `json:"api_keys"` // 按API Key选择预设
+}
+
+// modelConfigType 定义上游模型的上下文窗口与分词器
+type modelConfigType struct {
+	ContextSize     int    `json:"context_size"`     // 上下文窗口大小 (token)，0 为不限制
+	ResponseReserve int    `json:"response_reserve"` // 为回复预留的 token 数 (可选, 默认为 max_tokens)
+	TrimExamples    bool   `json:"trim_examples"`    // 超出上下文窗口时丢弃示例对话
+	Tokenizer       string `json:"tokenizer"`        // 分词器 cl100k_base, o200k_base, estimate (可选, 默认 estimate)
+}
+
+var config configType
+
+// CardSettings 返回创建角色卡时使用的设置
+func (c *configType) CardSettings() st.CardSettings {
+	return st.CardSettings{
+		MainPrompt:              c.Prompts.Main,
+		PostHistoryInstructions: c.Prompts.PostHistory,
+		PromptOrder:             c.Prompts.Order,
+		SystemExampleMessages:   c.Prompts.SystemExampleMessages,
+
+		WorldInfoScanDepth:         c.Lorebooks.ScanDepth,
+		WorldInfoMaxRecursionSteps: c.Lorebooks.MaxRecursionSteps,
+		WorldInfoMatchSources:      c.Lorebooks.MatchSources,
+	}
+}
+
+// Model 返回上游模型的配置，未列出的模型使用 "default"
+func (c *configType) Model(model string) modelConfigType {
+	if m, ok := c.Models[model]; ok {
+		return m
+	}
+	return c.Models["default"]
+}
+
+// ContextBudget 返回上游模型的上下文窗口与 World Info 的 token 预算，均未配置时返回 nil
+func (c *configType) ContextBudget(model string, maxTokens *int) *st.ContextBudget {
+	m := c.Model(model)
+	if m.ContextSize <= 0 && c.Lorebooks.BudgetCap <= 0 {
+		return nil
+	}
+	budget := &st.ContextBudget{
+		ContextSize:        m.ContextSize,
+		ResponseReserve:    m.ResponseReserve,
+		TrimExamples:       m.TrimExamples,
+		Counter:            modelTokenizer(model),
+		WorldInfoBudget:    c.Lorebooks.Budget,
+		WorldInfoBudgetCap: c.Lorebooks.BudgetCap,
+	}
+	if budget.ResponseReserve == 0 && maxTokens != nil {
+		budget.ResponseReserve = *maxTokens
+	}
+	return budget
+}
+
+// VectorSearch 返回向量化条目的相似度激活设置，baseURL 与 apiKey 为上游模型的接口地址与 API Key。
+// 未配置 lorebooks.vectors 时返回 nil，向量化条目仅按关键词匹配。
+func (c *configType) VectorSearch(baseURL string, apiKey string) *st.VectorSearch {
+	v := c.Lorebooks.Vectors
+	if v == nil {
+		return nil
+	}
+	indexSize := v.IndexSize
+	if indexSize <= 0 {
+		indexSize = 10000
+	}
+	search := &st.VectorSearch{
+		Index:      embedding.NewIndex(indexSize),
+		Threshold:  v.Threshold,
+		MaxEntries: v.MaxEntries,
+		QueryDepth: v.QueryDepth,
+	}
+	if v.Timeout > 0 {
+		search.Timeout = time.Duration(v.Timeout) * time.Second
+	}
+	if search.Threshold == 0 {
+		search.Threshold = 0.25
+	}
+	switch v.Embedder {
+	case "", embedding.HashingName:
+		search.Embedder = embedding.NewHashing(0)
+	case "openai":
+		if v.Model == "" {
+			log.Fatal().Msg("lorebooks.vectors.model is required for the openai embedder")
+		}
+		search.Embedder = embedding.NewOpenAI(cmp.Or(v.BaseURL, baseURL), cmp.Or(v.APIKey, apiKey), v.Model)
+	default:
+		log.Fatal().Str("embedder", v.Embedder).Msg("Unknown embedder in lorebooks.vectors")
+	}
+	return search
+}
+
+// loadConfig 读取 XITU_CONFIG 指定的配置文件 (默认为 config.json)
+func loadConfig() {
+	filePath := os.Getenv("XITU_CONFIG")
+	if filePath == "" {
+		filePath = "config.json"
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Warn().Err(err).Str("file_path", filePath).Msg("Failed to read config file, using defaults")
+		return
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Fatal().Err(err).Str("file_path", filePath).Msg("Failed to parse config file")
+	}
+	if config.Prompts.Order != nil {
+		if err := st.ValidatePromptOrder(config.Prompts.Order); err != nil {
+			log.Fatal().Err(err).Str("file_path", filePath).Msg("Invalid prompt order in config file")
+		}
+	}
+	log.Info().Str("file_path", filePath).Msg("Config loaded")
+}
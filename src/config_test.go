@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useConfig 写入并加载配置文件，测试结束后恢复原配置
+func useConfig(t *testing.T, data string) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = configType{}
+	filePath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filePath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XITU_CONFIG", filePath)
+	loadConfig()
+}
+
+func TestConfigCardSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		system bool
+	}{
+		{"system example messages", `{"prompts": {"main": "Main", "system_example_messages": true}}`, true},
+		{"user and assistant example messages", `{"prompts": {"main": "Main"}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.data)
+			settings := config.CardSettings()
+			if settings.SystemExampleMessages != tt.system {
+				t.Errorf("SystemExampleMessages = %v, want %v", settings.SystemExampleMessages, tt.system)
+			}
+			if settings.MainPrompt != "Main" {
+				t.Errorf("MainPrompt = %q, want Main", settings.MainPrompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/cloudwindy/xitu/st"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// loadInstructTemplate 返回同名的内置指令模板，或 instruct/ 目录下的 SillyTavern 指令模板
+func loadInstructTemplate(name string) (*st.InstructTemplate, error) {
+	if template, ok := st.BuiltinInstructTemplates()[name]; ok {
+		return template, nil
+	}
+	filePath := fmt.Sprintf("instruct/%s.json", name)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instruct template %s: %w", filePath, err)
+	}
+	template, err := st.ParseInstructTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse instruct template %s: %w", filePath, err)
+	}
+	return template, nil
+}
+
+// streamTextCompletion 请求上游的文本补全接口，并以聊天补全的流式格式返回，
+// 返回上游的响应是否已完整发送给客户端
+func streamTextCompletion(c *gin.Context, client *openai.Client, req openai.CompletionRequest, characterID string) bool {
+	stream, err := client.CreateCompletionStream(context.Background(), req)
+	if err != nil {
+		log.Error().Err(err).Str("character_id", characterID).Msg("OpenAI API call failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get response from AI"})
+		return false
+	}
+	var recvErr error
+	c.Stream(func(w io.Writer) bool {
+		response, err := stream.Recv()
+		if err != nil {
+			recvErr = err
+			return false
+		}
+		chunk := openai.ChatCompletionStreamResponse{
+			ID:      response.ID,
+			Object:  "chat.completion.chunk",
+			Created: response.Created,
+			Model:   response.Model,
+			Choices: make([]openai.ChatCompletionStreamChoice, 0, len(response.Choices)),
+		}
+		for _, choice := range response.Choices {
+			chunk.Choices = append(chunk.Choices, openai.ChatCompletionStreamChoice{
+				Index: choice.Index,
+				Delta: openai.ChatCompletionStreamChoiceDelta{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: choice.Text,
+				},
+				FinishReason: openai.FinishReason(choice.FinishReason),
+			})
+		}
+		c.SSEvent("message", chunk)
+		return true
+	})
+	if err = stream.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close stream")
+	}
+	return errors.Is(recvErr, io.EOF)
+}
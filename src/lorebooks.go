@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cloudwindy/xitu/st"
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	lorebookCache   = make(map[string]st.Lorebook)
+	lorebookCacheMu sync.Mutex
+)
+
+func loadLorebook(name string) (st.Lorebook, error) {
+	lorebookCacheMu.Lock()
+	defer lorebookCacheMu.Unlock()
+	if book, ok := lorebookCache[name]; ok {
+		return book, nil
+	}
+	filePath := fmt.Sprintf("lorebooks/%s.json", name)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to read lorebook file")
+		return nil, fmt.Errorf("lorebook not found")
+	}
+
+	lorebook, err := ccv3.ParseLorebook(data)
+	if err != nil {
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to parse lorebook")
+		return nil, fmt.Errorf("failed to parse lorebook")
+	}
+	diags := ccv3.ValidateLorebook(lorebook, "data")
+	for _, diag := range diags {
+		log.Warn().Str("file_path", filePath).Str("path", diag.Path).Str("severity", string(diag.Severity)).Msg(diag.Message)
+	}
+	if diags.HasError() {
+		return nil, fmt.Errorf("invalid lorebook: %w", diags.Errors())
+	}
+
+	book, err := st.NewLorebook(name, lorebook)
+	if err != nil {
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to load lorebook")
+		return nil, fmt.Errorf("failed to load lorebook")
+	}
+	lorebookCache[name] = book
+
+	return book, nil
+}
+
+// attachedLorebooks 返回附加到给定角色与API Key的设定集，依次为全局、角色、API Key
+func attachedLorebooks(characterID string, apiKey string) []st.Lorebook {
+	names := make([]string, 0)
+	names = append(names, config.Lorebooks.Global...)
+	names = append(names, config.Lorebooks.Characters[characterID]...)
+	if apiKey != "" {
+		names = append(names, config.Lorebooks.APIKeys[apiKey]...)
+	}
+
+	books := make([]st.Lorebook, 0, len(names))
+	for _, name := range names {
+		book, err := loadLorebook(name)
+		if err != nil {
+			log.Warn().Err(err).Str("lorebook", name).Str("character_id", characterID).Msg("Skipping attached lorebook")
+			continue
+		}
+		books = append(books, book)
+	}
+	return books
+}
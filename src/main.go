@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudwindy/xitu/st"
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/cloudwindy/xitu/st/tokenizer"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
@@ -20,10 +31,109 @@ import (
 )
 
 type ChatRequest struct {
-	Model       string                         `json:"model" binding:"required"`
-	Messages    []openai.ChatCompletionMessage `json:"messages" binding:"required"`
-	Temperature *float32                       `json:"temperature,omitempty"`
-	Stream      *bool                          `json:"stream,omitempty"`
+	Model          string                         `json:"model" binding:"required"`
+	Messages       []openai.ChatCompletionMessage `json:"messages" binding:"required"`
+	Temperature    *float32                       `json:"temperature,omitempty"`
+	Stream         *bool                          `json:"stream,omitempty"`
+	Seed           *int                           `json:"seed,omitempty"`
+	TopP           *float32                       `json:"top_p,omitempty"`
+	MaxTokens      *int                           `json:"max_tokens,omitempty"`
+	Greeting       *int                           `json:"greeting,omitempty"`                                                                                     // 开场白序号，0 为 first_mes
+	Group          bool                           `json:"group,omitempty"`                                                                                        // 是否为群聊
+	Preset         string                         `json:"preset,omitempty"`                                                                                       // 预设名称 (可选)
+	ChatID         string                         `json:"chat_id,omitempty"`                                                                                      // 聊天ID，用于保存 World Info 状态 (可选, 默认由聊天记录生成)
+	GenerationType string                         `json:"generation_type,omitempty" binding:"omitempty,oneof=normal continue impersonate swipe regenerate quiet"` // 生成类型 (可选, 默认 normal)
+}
+
+// worldInfoStates 保存各聊天的 World Info 状态
+var worldInfoStates = st.NewWorldInfoStore(24 * time.Hour)
+
+// vectorSearch 是向量化条目的相似度激活设置，在读取配置后初始化
+var vectorSearch *st.VectorSearch
+
+// authorize 检查请求的 API Key，无效时写入 401 响应并返回 false
+func authorize(c *gin.Context) (string, bool) {
+	auth, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		log.Warn().Str("header", c.GetHeader("Authorization")).Msg("Missing or invalid Authorization header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid Authorization header"})
+		return "", false
+	}
+	if !slices.Contains(validApiKeys, auth) {
+		log.Warn().Str("api_key", auth).Msg("Invalid API key")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return "", false
+	}
+	return auth, true
+}
+
+// applyErrorStatus 返回应用角色卡失败时的 HTTP 状态码
+func applyErrorStatus(err error) int {
+	if errors.Is(err, st.ErrGreetingOutOfRange) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// chatIDMessages 是未指定聊天ID时用于区分聊天的开头消息条数
+const chatIDMessages = 3
+
+// chatID 返回请求的聊天ID，未指定时由 API Key、角色ID (请求的 model) 与开头的几条消息生成
+func (req *ChatRequest) chatID(apiKey string) string {
+	if req.ChatID != "" {
+		return req.ChatID
+	}
+	characterID := req.Model
+	h := sha256.New()
+	h.Write([]byte(apiKey + "\x00" + characterID))
+	for _, message := range req.Messages[:min(chatIDMessages, len(req.Messages))] {
+		h.Write([]byte("\x00" + message.Role + "\x00" + message.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// ApplyOptions 返回应用角色卡时使用的参数，ctx 为请求的上下文，model 为上游模型名称。
+// State 为聊天状态的副本，上游成功响应后再由 commitState 保存。
+func (req *ChatRequest) ApplyOptions(ctx context.Context, apiKey string, model string) (st.ApplyOptions, error) {
+	preset, err := selectedPreset(req.Preset, apiKey)
+	if err != nil {
+		return st.ApplyOptions{}, err
+	}
+	return st.ApplyOptions{
+		Lorebooks:      attachedLorebooks(req.Model, apiKey),
+		Greeting:       req.Greeting,
+		Seed:           req.Seed,
+		Group:          req.Group,
+		Preset:         preset,
+		Budget:         config.ContextBudget(model, req.Sampling(preset).MaxTokens),
+		State:          worldInfoStates.Get(req.chatID(apiKey)).Clone(),
+		Vectors:        vectorSearch,
+		Context:        ctx,
+		GenerationType: req.GenerationType,
+	}, nil
+}
+
+// commitState 在上游成功响应后保存应用角色卡时更新的聊天状态
+func (req *ChatRequest) commitState(apiKey string, state *st.WorldInfoState) {
+	worldInfoStates.Commit(req.chatID(apiKey), state)
+}
+
+// Sampling 返回请求上游模型时使用的采样参数，请求中的参数优先于预设
+func (req *ChatRequest) Sampling(preset *st.Preset) st.SamplingParams {
+	sampling := st.SamplingParams{}
+	if preset != nil {
+		sampling = preset.Sampling
+	}
+	if req.Temperature != nil {
+		sampling.Temperature = req.Temperature
+	}
+	if req.TopP != nil {
+		sampling.TopP = req.TopP
+	}
+	if req.MaxTokens != nil {
+		sampling.MaxTokens = req.MaxTokens
+	}
+	return sampling
 }
 
 func setupLogger() {
@@ -74,29 +184,110 @@ func GinLogger() gin.HandlerFunc {
 	}
 }
 
-var cache = make(map[string]st.Card)
+// characterType 是一个已加载的角色卡及其原始文件
+type characterType struct {
+	st.Card
+	image []byte          // 原始 PNG 图片 (可选, 导出 PNG 时使用)
+	icon  []byte          // 默认图标，PNG 角色卡为图片本身，CHARX 为内嵌的 main 图标 (可选, 解析 ccdefault: 时使用)
+	files ccv3.AssetFiles // CHARX 内嵌资源文件 (可选)
+}
+
+// Assets 返回角色卡的资源列表，未定义资源时使用默认图标
+func (c *characterType) Assets() []ccv3.Asset {
+	assets := c.GetData().Assets
+	if len(assets) == 0 {
+		return []ccv3.Asset{{Type: "icon", URI: ccv3.AssetURIDefault, Name: "main", Ext: "png"}}
+	}
+	return assets
+}
+
+// CharacterCard 返回用于导出的 V3 角色卡
+func (c *characterType) CharacterCard() ccv3.CharacterCard {
+	return ccv3.CharacterCard{
+		Spec:        ccv3.SpecV3,
+		SpecVersion: ccv3.SpecVersionV3,
+		Data:        c.GetData(),
+	}
+}
+
+var (
+	cache   = make(map[string]*characterType)
+	cacheMu sync.RWMutex
+)
 var validApiKeys = []string{
 	"sk-96oyf8lafovtov62", // Example key for testing
 }
 
-func loadCharacter(characterID string) (st.Card, error) {
-	if card, ok := cache[characterID]; ok {
+// maxCardUploadSize 是校验接口接受的角色卡文件的最大字节数
+const maxCardUploadSize = 32 << 20
+
+// characterFileExts 是角色卡文件支持的扩展名，按查找顺序排列
+var characterFileExts = []string{"json", "png", "charx"}
+
+// isLocalName 判断名称可以安全地用作目录下的文件名，即不为空、不含路径分隔符与 ..
+func isLocalName(name string) bool {
+	return filepath.IsLocal(name) && !strings.ContainsAny(name, `/\`) && !strings.Contains(name, "..")
+}
+
+func loadCharacter(characterID string) (*characterType, error) {
+	if !isLocalName(characterID) {
+		log.Warn().Str("character_id", characterID).Msg("Invalid character ID")
+		return nil, fmt.Errorf("character not found")
+	}
+	cacheMu.RLock()
+	card, ok := cache[characterID]
+	cacheMu.RUnlock()
+	if ok {
 		return card, nil
 	}
-	filePath := fmt.Sprintf("characters/%s.json", characterID)
-
-	data, err := os.ReadFile(filePath)
+	var filePath string
+	var data []byte
+	var err error
+	for _, ext := range characterFileExts {
+		filePath = fmt.Sprintf("characters/%s.%s", characterID, ext)
+		data, err = os.ReadFile(filePath)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
 	if err != nil {
 		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to read character file")
 		return nil, fmt.Errorf("character not found")
 	}
 
-	card, err := st.NewCard(data)
+	// Read the CHARX archive once and parse its card.json
+	var files ccv3.AssetFiles
+	cardData := data
+	if ccv3.IsCHARX(data) {
+		cardData, files, err = ccv3.ReadCHARX(data)
+		if err != nil {
+			log.Error().Err(err).Str("file_path", filePath).Msg("Failed to read CHARX archive")
+			return nil, fmt.Errorf("failed to parse character card")
+		}
+	}
+
+	c, err := st.NewCard(cardData, config.CardSettings())
+	if diags := (ccv3.Diagnostics{}); errors.As(err, &diags) {
+		for _, diag := range diags {
+			log.Error().Str("file_path", filePath).Str("path", diag.Path).Msg(diag.Message)
+		}
+		return nil, fmt.Errorf("invalid character card: %w", diags)
+	}
 	if err != nil {
-		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to parse character json")
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to parse character card")
 		return nil, fmt.Errorf("failed to parse character card")
 	}
+	card = &characterType{Card: c, files: files}
+	if ccv3.IsPNG(data) {
+		card.image = data
+		card.icon = data
+	} else if files != nil {
+		card.icon = files.DefaultIcon(c.GetData().Assets)
+		log.Debug().Int("count", len(files)).Str("file_path", filePath).Msg("Character assets loaded")
+	}
+	cacheMu.Lock()
 	cache[characterID] = card
+	cacheMu.Unlock()
 
 	return card, nil
 }
@@ -115,6 +306,7 @@ func main() {
 		gin.SetMode(gin.DebugMode)
 	}
 	setupLogger()
+	loadConfig()
 
 	info, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -131,6 +323,22 @@ func main() {
 		log.Fatal().Msg("OpenAI API configuration is missing. Please set OPENAI_BASE_URL, OPENAI_API_KEY, and OPENAI_MODEL in your .env file")
 	}
 
+	// Use the text completion API with an instruct template if configured
+	var instruct *st.InstructTemplate
+	if name := os.Getenv("OPENAI_INSTRUCT_TEMPLATE"); name != "" {
+		var err error
+		instruct, err = loadInstructTemplate(name)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load instruct template")
+		}
+		log.Info().Str("template", instruct.Name).Msg("Using text completion with instruct template")
+	}
+
+	vectorSearch = config.VectorSearch(baseURL, apiKey)
+	if vectorSearch != nil {
+		log.Info().Str("embedder", vectorSearch.Embedder.Name()).Msg("Vector search enabled")
+	}
+
 	r := gin.New()
 	r.Use(GinLogger())
 	r.Use(gin.Recovery())
@@ -139,6 +347,72 @@ func main() {
 		c.String(http.StatusOK, "XITU is running")
 	})
 
+	r.POST("/api/character/validate", func(c *gin.Context) {
+		if _, ok := authorize(c); !ok {
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCardUploadSize)
+		body, err := c.GetRawData()
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			log.Warn().Int64("limit", maxBytesErr.Limit).Msg("Character card too large")
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Character card too large"})
+			return
+		}
+		if err != nil {
+			log.Warn().Err(err).Msg("Invalid request body")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		diags := ccv3.Diagnostics{}
+		data, err := ccv3.ReadCardJSON(body)
+		var card ccv3.CharacterCard
+		var spec string
+		if err == nil {
+			card, spec, err = ccv3.Parse(data)
+		}
+		if err != nil {
+			diags = append(diags, ccv3.Diagnostic{Path: "", Severity: ccv3.SeverityError, Message: err.Error()})
+		} else {
+			diags = ccv3.Validate(card)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":       !diags.HasError(),
+			"spec":        spec,
+			"diagnostics": diags,
+		})
+	})
+
+	r.POST("/api/tokenize", func(c *gin.Context) {
+		if _, ok := authorize(c); !ok {
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1<<20)
+		req := struct {
+			Model string `json:"model"`                    // 上游模型名称 (可选, 默认为 OPENAI_MODEL)
+			Text  string `json:"text" binding:"max=65536"` // 待分词的文本，最多 65536 个字符
+		}{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.Warn().Err(err).Msg("Invalid request body")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.Model == "" {
+			req.Model = model
+		}
+
+		t := modelTokenizer(req.Model)
+		response := gin.H{
+			"tokenizer": t.Name(),
+			"count":     t.Count(req.Text),
+		}
+		if encoder, ok := t.(tokenizer.Encoder); ok {
+			response["tokens"] = encoder.Encode(req.Text)
+		}
+		c.JSON(http.StatusOK, response)
+	})
+
 	r.GET("/api/character/:id", func(c *gin.Context) {
 		characterID := c.Param("id")
 
@@ -148,14 +422,104 @@ func main() {
 			return
 		}
 
+		assets := make([]gin.H, 0)
+		for _, asset := range card.Assets() {
+			assets = append(assets, gin.H{
+				"type": asset.Type,
+				"name": asset.Name,
+				"ext":  asset.Ext,
+				"url":  fmt.Sprintf("/api/character/%s/assets/%s?type=%s", characterID, url.PathEscape(asset.Name), url.QueryEscape(asset.Type)),
+			})
+		}
+
+		greetings := card.GetGreetings(false)
+		groupGreetings := card.GetGreetings(true)[len(greetings):]
+
 		c.JSON(http.StatusOK, gin.H{
-			"name":         card.GetData().Name,
-			"version":      card.GetData().CharacterVersion,
-			"creator":      card.GetData().Creator,
-			"creatornotes": card.GetData().CreatorNotes,
-			"tags":         card.GetData().Tags,
-			"firstmes":     card.GetData().FirstMes,
+			"name":           card.GetData().Name,
+			"version":        card.GetData().CharacterVersion,
+			"creator":        card.GetData().Creator,
+			"creatornotes":   card.GetData().CreatorNotes,
+			"tags":           card.GetData().Tags,
+			"firstmes":       card.GetData().FirstMes,
+			"greetings":      greetings,
+			"groupgreetings": groupGreetings,
+			"assets":         assets,
+		})
+	})
+
+	r.GET("/api/character/:id/assets/:name", func(c *gin.Context) {
+		characterID := c.Param("id")
+		name := c.Param("name")
+		assetType := c.Query("type")
+
+		card, err := loadCharacter(characterID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		idx := slices.IndexFunc(card.Assets(), func(a ccv3.Asset) bool {
+			return a.Name == name && (assetType == "" || a.Type == assetType)
 		})
+		if idx < 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+			return
+		}
+		asset := card.Assets()[idx]
+		// Remote URIs come from the untrusted card, return them instead of redirecting
+		if strings.HasPrefix(asset.URI, "http://") || strings.HasPrefix(asset.URI, "https://") {
+			c.JSON(http.StatusOK, gin.H{"url": asset.URI})
+			return
+		}
+
+		data, err := card.files.Resolve(asset, card.icon)
+		if err != nil {
+			log.Warn().Err(err).Str("character_id", characterID).Str("asset", name).Msg("Failed to resolve asset")
+			c.JSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+			return
+		}
+		contentType := mime.TypeByExtension("." + asset.Ext)
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		c.Data(http.StatusOK, contentType, data)
+	})
+
+	r.GET("/api/character/:id/export", func(c *gin.Context) {
+		characterID := c.Param("id")
+
+		card, err := loadCharacter(characterID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		format := c.DefaultQuery("format", "png")
+		buf := bytes.Buffer{}
+		var contentType string
+		switch format {
+		case "json":
+			contentType = "application/json"
+			err = ccv3.WriteJSON(&buf, card.CharacterCard())
+		case "png":
+			contentType = "image/png"
+			err = ccv3.WritePNG(&buf, card.image, card.CharacterCard())
+		case "charx":
+			contentType = "application/zip"
+			err = ccv3.WriteCHARX(&buf, card.CharacterCard(), card.files)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of png, json, charx"})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("character_id", characterID).Str("format", format).Msg("Failed to export character card")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export character card"})
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, characterID, format))
+		c.Data(http.StatusOK, contentType, buf.Bytes())
 	})
 
 	if gin.Mode() == gin.DebugMode {
@@ -173,14 +537,36 @@ func main() {
 				return
 			}
 
-			messages, err := card.Apply(req.Messages)
+			apiKey, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			opts, err := req.ApplyOptions(c.Request.Context(), apiKey, model)
+			if err != nil {
+				c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			// Preview without committing the chat state
+			report := st.ApplyReport{}
+			opts.Report = &report
+
+			if instruct != nil {
+				prompt, stop, err := card.ApplyText(req.Messages, instruct, opts)
+				if err != nil {
+					log.Error().Err(err).Str("character_id", req.Model).Msg("Failed to apply messages")
+					c.JSON(applyErrorStatus(err), gin.H{"error": err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"prompt": prompt, "stop": stop, "report": report})
+				return
+			}
+
+			messages, err := card.Apply(req.Messages, opts)
 			if err != nil {
 				log.Error().Err(err).Str("character_id", req.Model).Msg("Failed to apply messages")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.JSON(applyErrorStatus(err), gin.H{"error": err.Error()})
 				return
 			}
 
-			c.JSON(http.StatusOK, gin.H{"messages": messages})
+			c.JSON(http.StatusOK, gin.H{"messages": messages, "report": report})
 		})
 	}
 
@@ -192,15 +578,8 @@ func main() {
 			return
 		}
 
-		auth, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		auth, ok := authorize(c)
 		if !ok {
-			log.Warn().Str("header", c.GetHeader("Authorization")).Msg("Missing or invalid Authorization header")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid Authorization header"})
-			return
-		}
-		if !slices.Contains(validApiKeys, auth) {
-			log.Warn().Str("api_key", auth).Msg("Invalid API key")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 			return
 		}
 
@@ -210,24 +589,77 @@ func main() {
 			return
 		}
 
-		config := openai.DefaultConfig(apiKey)
-		config.BaseURL = baseURL
+		clientConfig := openai.DefaultConfig(apiKey)
+		clientConfig.BaseURL = baseURL
+		client := openai.NewClientWithConfig(clientConfig)
+
+		opts, err := req.ApplyOptions(c.Request.Context(), auth, model)
+		if err != nil {
+			c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		sampling := req.Sampling(opts.Preset)
+
+		if instruct != nil {
+			prompt, stop, err := card.ApplyText(req.Messages, instruct, opts)
+			if err != nil {
+				log.Error().Err(err).Str("character_id", req.Model).Msg("Failed to apply messages")
+				c.JSON(applyErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			completionReq := openai.CompletionRequest{
+				Model:       model,
+				Prompt:      prompt,
+				Stop:        stop,
+				Temperature: 1,
+			}
+			if sampling.Temperature != nil {
+				completionReq.Temperature = *sampling.Temperature
+			}
+			if sampling.TopP != nil {
+				completionReq.TopP = *sampling.TopP
+			}
+			if sampling.FrequencyPenalty != nil {
+				completionReq.FrequencyPenalty = *sampling.FrequencyPenalty
+			}
+			if sampling.PresencePenalty != nil {
+				completionReq.PresencePenalty = *sampling.PresencePenalty
+			}
+			if sampling.MaxTokens != nil {
+				completionReq.MaxTokens = *sampling.MaxTokens
+			}
+			if streamTextCompletion(c, client, completionReq, req.Model) {
+				req.commitState(auth, opts.State)
+			}
+			return
+		}
 
-		messages, err := card.Apply(req.Messages)
+		messages, err := card.Apply(req.Messages, opts)
 		if err != nil {
 			log.Error().Err(err).Str("character_id", req.Model).Msg("Failed to apply messages")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(applyErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		client := openai.NewClientWithConfig(config)
 		openAIReq := openai.ChatCompletionRequest{
 			Model:       model,
 			Messages:    messages,
 			Temperature: 1,
 		}
-		if req.Temperature != nil {
-			openAIReq.Temperature = *req.Temperature
+		if sampling.Temperature != nil {
+			openAIReq.Temperature = *sampling.Temperature
+		}
+		if sampling.TopP != nil {
+			openAIReq.TopP = *sampling.TopP
+		}
+		if sampling.FrequencyPenalty != nil {
+			openAIReq.FrequencyPenalty = *sampling.FrequencyPenalty
+		}
+		if sampling.PresencePenalty != nil {
+			openAIReq.PresencePenalty = *sampling.PresencePenalty
+		}
+		if sampling.MaxTokens != nil {
+			openAIReq.MaxTokens = *sampling.MaxTokens
 		}
 		stream, err := client.CreateChatCompletionStream(context.Background(), openAIReq)
 		if err != nil {
@@ -235,9 +667,11 @@ func main() {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get response from AI"})
 			return
 		}
+		var recvErr error
 		c.Stream(func(w io.Writer) bool {
 			response, err := stream.Recv()
 			if err != nil {
+				recvErr = err
 				return false
 			}
 			c.SSEvent("message", response)
@@ -247,6 +681,10 @@ func main() {
 			log.Error().Err(err).Msg("Failed to close stream")
 			return
 		}
+		// Keep the chat state unchanged if the response failed or the client left, so a retry sees the same state
+		if errors.Is(recvErr, io.EOF) {
+			req.commitState(auth, opts.State)
+		}
 	})
 
 	if err := r.Run(":8080"); err != nil {
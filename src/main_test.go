@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestIsLocalName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"alice", true},
+		{"alice.v2", true},
+		{"", false},
+		{"..", false},
+		{"../alice", false},
+		{"a..b", false},
+		{"chars/alice", false},
+		{`chars\alice`, false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLocalName(tt.name); got != tt.want {
+				t.Errorf("isLocalName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCharacter(t *testing.T) {
+	dir := t.TempDir()
+	card := `{"spec":"chara_card_v3","spec_version":"3.0","data":{"name":"Alice"}}`
+	for _, file := range []string{"characters/alice.json", "secret.json"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(card), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	cache = make(map[string]*characterType)
+
+	for _, id := range []string{"../secret", "..", "characters/alice", "missing"} {
+		if _, err := loadCharacter(id); err == nil {
+			t.Errorf("loadCharacter(%q) succeeded", id)
+		}
+	}
+
+	// Concurrent requests share the cache
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c, err := loadCharacter("alice"); err != nil || c.GetName() != "Alice" {
+				t.Errorf("loadCharacter() = %v, %v", c, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(cache) != 1 {
+		t.Errorf("cache has %d characters, want 1", len(cache))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/cloudwindy/xitu/st"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	presetCache   = make(map[string]*st.Preset)
+	presetCacheMu sync.Mutex
+)
+
+// 加载预设失败的原因
+var (
+	errInvalidPresetName = errors.New("invalid preset name")
+	errPresetNotFound    = errors.New("preset not found")
+	errBrokenPreset      = errors.New("failed to parse preset")
+)
+
+// presetErrorStatus 返回加载预设失败时的 HTTP 状态码
+func presetErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidPresetName):
+		return http.StatusBadRequest
+	case errors.Is(err, errPresetNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func loadPreset(name string) (*st.Preset, error) {
+	if !isLocalName(name) {
+		return nil, errInvalidPresetName
+	}
+	presetCacheMu.Lock()
+	defer presetCacheMu.Unlock()
+	if preset, ok := presetCache[name]; ok {
+		return preset, nil
+	}
+	filePath := fmt.Sprintf("presets/%s.json", name)
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to read preset file")
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errPresetNotFound
+		}
+		return nil, errBrokenPreset
+	}
+
+	preset, err := st.ParsePreset(name, data)
+	if err != nil {
+		log.Error().Err(err).Str("file_path", filePath).Msg("Failed to parse preset")
+		return nil, errBrokenPreset
+	}
+	presetCache[name] = preset
+
+	return preset, nil
+}
+
+// selectedPreset 返回请求使用的预设，优先级依次为请求指定、API Key 配置、默认配置
+func selectedPreset(requested string, apiKey string) (*st.Preset, error) {
+	name := requested
+	if name == "" && apiKey != "" {
+		name = config.Presets.APIKeys[apiKey]
+	}
+	if name == "" {
+		name = config.Presets.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return loadPreset(name)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// writePresets 在临时目录的 presets/ 下写入预设文件，并切换到该目录
+func writePresets(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "presets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "presets", name+".json"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	presetCache = make(map[string]*st.Preset)
+}
+
+func TestLoadPreset(t *testing.T) {
+	writePresets(t, map[string]string{"good": `{"temperature": 0.5}`, "broken": `{`})
+	tests := []struct {
+		name       string
+		wantErr    error
+		wantStatus int
+	}{
+		{"good", nil, http.StatusOK},
+		{"missing", errPresetNotFound, http.StatusNotFound},
+		{"broken", errBrokenPreset, http.StatusInternalServerError},
+		{"../good", errInvalidPresetName, http.StatusBadRequest},
+		{"..", errInvalidPresetName, http.StatusBadRequest},
+		{"presets/good", errInvalidPresetName, http.StatusBadRequest},
+		{`..\good`, errInvalidPresetName, http.StatusBadRequest},
+		{"/etc/passwd", errInvalidPresetName, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, err := loadPreset(tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadPreset() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if status := presetErrorStatus(err); status != tt.wantStatus {
+					t.Errorf("presetErrorStatus() = %d, want %d", status, tt.wantStatus)
+				}
+				return
+			}
+			if preset.Name != tt.name {
+				t.Errorf("preset name = %q, want %q", preset.Name, tt.name)
+			}
+		})
+	}
+}
+
+func TestSelectedPreset(t *testing.T) {
+	writePresets(t, map[string]string{"request": `{}`, "key": `{}`, "default": `{}`})
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.Presets = presetConfigType{Default: "default", APIKeys: map[string]string{"sk-key": "key"}}
+
+	tests := []struct {
+		name      string
+		requested string
+		apiKey    string
+		want      string
+	}{
+		{"requested wins", "request", "sk-key", "request"},
+		{"api key", "", "sk-key", "key"},
+		{"default", "", "sk-other", "default"},
+		{"no api key", "", "", "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, err := selectedPreset(tt.requested, tt.apiKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if preset.Name != tt.want {
+				t.Errorf("selectedPreset() = %q, want %q", preset.Name, tt.want)
+			}
+		})
+	}
+
+	config.Presets = presetConfigType{}
+	if preset, err := selectedPreset("", "sk-key"); preset != nil || err != nil {
+		t.Errorf("selectedPreset() = %v, %v, want no preset", preset, err)
+	}
+}
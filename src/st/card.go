@@ -1,7 +1,8 @@
 package st
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -16,8 +17,29 @@ type Card interface {
 	GetName() string
 	// GetData 返回角色卡的完整数据
 	GetData() ccv3.CharacterCardData
+	// GetGreetings 返回所有开场白 (first_mes 及 alternate_greetings)，group 为 true 时包含仅群聊使用的开场白
+	GetGreetings(group bool) []string
 	// Apply 将角色卡应用到给定的消息数组
-	Apply([]openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error)
+	Apply([]openai.ChatCompletionMessage, ...ApplyOptions) ([]openai.ChatCompletionMessage, error)
+	// ApplyText 将角色卡应用到给定的消息数组，并使用指令模板渲染为文本补全的提示与停止字符串
+	ApplyText([]openai.ChatCompletionMessage, *InstructTemplate, ...ApplyOptions) (string, []string, error)
+}
+
+// ApplyOptions 定义单次应用角色卡时的可选参数
+type ApplyOptions struct {
+	Lorebooks []Lorebook // 附加的独立设定集，与角色卡自带的设定集一同扫描
+	Greeting  *int       // 开场白序号，对应 GetGreetings 的返回值 (可选)
+	Seed      *int       // 未指定开场白序号时，用于确定性地选择开场白 (可选, 默认使用 first_mes)
+	Group     bool       // 是否为群聊，群聊时可选择仅群聊使用的开场白
+	Preset    *Preset    // 覆盖角色卡提示词组装设置的预设 (可选)
+
+	Budget  *ContextBudget  // 上下文窗口预算，超出时丢弃最早的聊天记录 (可选, 默认不限制)
+	Report  *ApplyReport    // 设置时写入组装结果 (可选)
+	State   *WorldInfoState // 聊天的 World Info 状态，用于 sticky 与 cooldown (可选, 默认不保存状态)
+	Vectors *VectorSearch   // 向量化条目的相似度激活 (可选, 默认不激活向量化条目)
+	Context context.Context // 请求的上下文，取消时中止向量化 (可选, 默认 context.Background)
+
+	GenerationType string // 生成类型，仅激活 triggers 包含此类型的条目，取值见 ccv3.Triggers (可选, 默认 normal)
 }
 
 type CardSettings struct {
@@ -25,30 +47,53 @@ type CardSettings struct {
 	UserPersona    string
 	NewMainChat    string
 	NewExampleChat string
+
+	SystemExampleMessages bool          // 示例对话以带 name (example_user/example_assistant) 的 system 消息发送，而非 user/assistant 消息
+	PromptOrder           []PromptBlock // 提示词顺序 (可选, 默认为 DefaultPromptOrder)
+	SquashSystemMessages  bool          // 合并相邻的 system 消息
+
+	WorldInfoScanDepth         int           // World Info 的默认扫描深度，条目与设定集均未设置时使用 (可选, 默认 2)
+	WorldInfoMaxRecursionSteps int           // World Info 的最大递归扫描次数 (可选, 0 为不限制)
+	WorldInfoMatchSources      []string      // 对所有条目扫描的匹配来源名称，如 persona, description (可选)
+	MatchSources               []MatchSource // World Info 的匹配来源 (可选, 默认为 DefaultMatchSources)
+
+	MainPrompt              string // 默认主提示词，角色卡 system_prompt 中的 {{original}} 会替换为此内容
+	PostHistoryInstructions string // 默认后历史指令，角色卡 post_history_instructions 中的 {{original}} 会替换为此内容
 }
 
-// NewCard 解析并返回一个新的 Card 实例
+// NewCard 解析并返回一个新的 Card 实例，data 可以是 JSON、内嵌角色卡的 PNG 图片或 CHARX 压缩包
 func NewCard(data []byte, settings ...CardSettings) (Card, error) {
-	c := ccv3.CharacterCard{}
-	if err := json.Unmarshal(data, &c); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	data, err := ccv3.ReadCardJSON(data)
+	if err != nil {
+		return nil, err
 	}
-	if c.Spec != "chara_card_v3" || c.SpecVersion != "3.0" {
-		return nil, fmt.Errorf("invalid spec or spec_version")
+	c, spec, err := ccv3.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if spec != ccv3.SpecV3 {
+		log.Info().Str("name", c.Data.Name).Str("spec", spec).Msg("Character card upgraded to V3")
 	}
-	if c.Data.Name == "" {
-		return nil, fmt.Errorf("character name is required")
+	diags := ccv3.Validate(c)
+	if diags.HasError() {
+		return nil, diags.Errors()
+	}
+	for _, diag := range diags.Warnings() {
+		log.Warn().Str("name", c.Data.Name).Str("path", diag.Path).Msg(diag.Message)
 	}
 	card := &cardType{
 		data: c.Data,
 	}
 	if c.Data.CharacterBook != nil && len(c.Data.CharacterBook.Entries) > 0 {
-		entries, err := newLorebookEntriesFromCCV3(c.Data.CharacterBook.Entries)
+		name := c.Data.CharacterBook.Name
+		if name == "" {
+			name = c.Data.Name
+		}
+		book, err := newLorebook(name, *c.Data.CharacterBook)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse CharacterBook entries: %w", err)
 		}
-		log.Debug().Int("count", len(c.Data.CharacterBook.Entries)).Msg("CharacterBook entries loaded")
-		card.lorebook = entries
+		card.lorebook = book.entries
 	}
 	if len(settings) > 0 {
 		card.CardSettings = settings[0]
@@ -74,6 +119,23 @@ func (c *cardType) initDefaultSettings() {
 	if s.NewExampleChat == "" {
 		s.NewExampleChat = "[Example Chat]"
 	}
+	if s.PromptOrder == nil {
+		s.PromptOrder = DefaultPromptOrder()
+	}
+	if s.WorldInfoScanDepth <= 0 {
+		s.WorldInfoScanDepth = 2
+	}
+	if s.MatchSources == nil {
+		s.MatchSources = DefaultMatchSources()
+	}
+}
+
+// withSettings 返回使用给定设置的角色卡副本
+func (c *cardType) withSettings(settings CardSettings) *cardType {
+	card := *c
+	card.CardSettings = settings
+	card.initDefaultSettings()
+	return &card
 }
 
 func (c *cardType) GetName() string {
@@ -84,60 +146,98 @@ func (c *cardType) GetData() ccv3.CharacterCardData {
 	return c.data
 }
 
-func (c *cardType) Apply(openAIMessages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error) {
-	history, err := c.parseOpenAIMessages(openAIMessages)
+func (c *cardType) GetGreetings(group bool) []string {
+	greetings := make([]string, 0, 1+len(c.data.AlternateGreetings)+len(c.data.GroupOnlyGreetings))
+	greetings = append(greetings, c.processPrompt(c.data.FirstMes))
+	for _, greeting := range c.data.AlternateGreetings {
+		greetings = append(greetings, c.processPrompt(greeting))
+	}
+	if group {
+		for _, greeting := range c.data.GroupOnlyGreetings {
+			greetings = append(greetings, c.processPrompt(greeting))
+		}
+	}
+	return greetings
+}
+
+func (c *cardType) Apply(openAIMessages []openai.ChatCompletionMessage, options ...ApplyOptions) ([]openai.ChatCompletionMessage, error) {
+	messages, err := c.apply(openAIMessages, options...)
 	if err != nil {
 		return nil, err
 	}
+	return c.toOpenAIMessages(messages), nil
+}
 
-	ev := log.Debug().Int("entries", c.lorebook.Len())
-
-	charDefs := c.buildCharDefMessages()
-	ev.Int("charDefs", len(charDefs))
-
-	examples := c.buildExampleMessages(c.data.MesExample)
-	ev.Int("examples", len(examples))
+func (c *cardType) ApplyText(openAIMessages []openai.ChatCompletionMessage, template *InstructTemplate, options ...ApplyOptions) (string, []string, error) {
+	messages, err := c.apply(openAIMessages, options...)
+	if err != nil {
+		return "", nil, err
+	}
+	generationType := ""
+	if len(options) > 0 {
+		generationType = options[0].GenerationType
+	}
+	prompt := template.render(messages, c.UserName, c.charName(), generationType)
+	log.Debug().Str("template", template.Name).Int("length", len(prompt)).Msg("Text prompt rendered")
+	return prompt, template.StopStrings(), nil
+}
 
-	messages := make([]messageType, 0, len(charDefs)+len(history))
-	wi, err := c.checkWorldInfo(history)
+func (c *cardType) apply(openAIMessages []openai.ChatCompletionMessage, options ...ApplyOptions) ([]messageType, error) {
+	opts := ApplyOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	history, err := c.parseOpenAIMessages(openAIMessages, opts.GenerationType)
 	if err != nil {
 		return nil, err
 	}
-	if wi == nil {
-		messages = append(messages, charDefs...)
-		messages = append(messages, examples...)
-	} else {
-		c.applyLorebookEntries(&messages, wi.BeforeCharDefs)
-		messages = append(messages, charDefs...)
-		c.applyLorebookEntries(&messages, wi.AfterCharDefs)
-
-		beforeEM := c.buildExampleMessages(c.joinLorebookEntries(wi.BeforeExampleMessages))
-		afterEM := c.buildExampleMessages(c.joinLorebookEntries(wi.AfterExampleMessages))
-		messages = append(messages, beforeEM...)
-		messages = append(messages, examples...)
-		messages = append(messages, afterEM...)
-
-		ev.Int("activated", wi.ActivatedCount())
+	if last := &history[len(history)-1]; opts.GenerationType == ccv3.TriggerContinue && last.Role == assistant {
+		last.continued = true
+	}
+	if opts.Preset != nil {
+		c = c.withSettings(opts.Preset.ApplyTo(c.CardSettings))
+		log.Debug().Str("preset", opts.Preset.Name).Msg("Preset applied")
+	}
+	lorebook := c.mergeLorebooks(opts.Lorebooks)
+	history, err = c.injectGreeting(history, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	mainChat := c.buildMainChat(history, wi)
-	messages = append(messages, mainChat...)
-
-	ev.Int("mainChat", len(mainChat)).
+	if opts.Report == nil {
+		opts.Report = &ApplyReport{}
+	}
+	wi, err := c.checkWorldInfo(history, lorebook, opts)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := c.buildPrompt(history, wi, opts.Budget, opts.Report)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().
+		Int("entries", lorebook.Len()).
 		Int("total", len(messages)).
 		Msg("CharacterCard applied")
 
-	return c.toOpenAIMessages(messages), nil
+	return messages, nil
 }
 
-func (c *cardType) parseOpenAIMessages(openAIMessages []openai.ChatCompletionMessage) ([]messageType, error) {
+// parseOpenAIMessages 解析输入的聊天记录，最后一条消息须为非空的用户消息；
+// continue 与 impersonate 续写或代写已有的聊天，最后一条消息也可以是助手消息
+func (c *cardType) parseOpenAIMessages(openAIMessages []openai.ChatCompletionMessage, generationType string) ([]messageType, error) {
+	continues := generationType == ccv3.TriggerContinue || generationType == ccv3.TriggerImpersonate
 	messages := make([]messageType, 0, len(openAIMessages))
 	for i, openAIMessage := range openAIMessages {
 		if openAIMessage.Role == openai.ChatMessageRoleSystem {
 			return nil, fmt.Errorf("input messages should not contain 'system' Role")
 		}
-		if i == len(openAIMessages)-1 && (openAIMessage.Role != openai.ChatMessageRoleUser || openAIMessage.Content == "") {
-			return nil, fmt.Errorf("the last messageType must be a non-empty user messageType")
+		if i == len(openAIMessages)-1 && openAIMessage.Content == "" {
+			return nil, fmt.Errorf("the last messageType must not be empty")
+		}
+		if i == len(openAIMessages)-1 && openAIMessage.Role != openai.ChatMessageRoleUser &&
+			(!continues || openAIMessage.Role != openai.ChatMessageRoleAssistant) {
+			return nil, fmt.Errorf("the last messageType must be a user messageType, or an assistant messageType for %s and %s", ccv3.TriggerContinue, ccv3.TriggerImpersonate)
 		}
 		msg, err := parseOpenAIMessage(openAIMessage)
 		if err != nil {
@@ -148,78 +248,39 @@ func (c *cardType) parseOpenAIMessages(openAIMessages []openai.ChatCompletionMes
 	return messages, nil
 }
 
-func (c *cardType) toOpenAIMessages(messages []messageType) []openai.ChatCompletionMessage {
-	openAIMessages := make([]openai.ChatCompletionMessage, 0, len(messages))
-	for _, msg := range messages {
-		openAIMessages = append(openAIMessages, msg.ToOpenAIMessage())
-	}
-	return openAIMessages
-}
+// ErrGreetingOutOfRange 表示请求的开场白序号超出角色卡开场白的范围
+var ErrGreetingOutOfRange = errors.New("greeting index out of range")
 
-func (c *cardType) buildCharDefMessages() []messageType {
-	messages := make([]messageType, 0)
-	c.pushPrompt(&messages, system, c.UserPersona)
-	c.pushPrompt(&messages, system, c.data.Description)
-	c.pushPrompt(&messages, system, c.data.Personality)
-	c.pushPrompt(&messages, system, c.data.Scenario)
-	log.Debug().Int("count", len(c.lorebook)).Msg("CharDef built")
-	return messages
-}
-
-func (c *cardType) buildExampleMessages(mesExample string) []messageType {
-	if mesExample == "" {
-		return nil
-	}
-	messages := make([]messageType, 0)
-	examples := strings.Split(mesExample, "<START>")
-	const delim = "\x01\x02"
-	replacer := strings.NewReplacer(
-		"{{user}}:", delim,
-		"{{char}}:", delim,
-	)
-	entries := 0
-	lines := 0
-	for _, example := range examples {
-		if c.processPrompt(example) == "" {
-			continue
-		}
-		c.pushPrompt(&messages, system, c.NewExampleChat)
-		entries++
-		example = replacer.Replace(example)
-		for _, line := range strings.Split(example, delim) {
-			if c.processPrompt(line) == "" {
-				continue
-			}
-			c.pushPrompt(&messages, system, line)
-			lines++
+// injectGreeting 在历史消息不以助手消息开头时，将选中的开场白作为第一条助手消息插入。
+// 以助手消息开头的历史视为已包含开场白 (可能经过客户端编辑或切换)。
+func (c *cardType) injectGreeting(history []messageType, opts ApplyOptions) ([]messageType, error) {
+	if len(history) > 0 && history[0].Role == assistant {
+		return history, nil
+	}
+	greetings := c.GetGreetings(opts.Group)
+	index := 0
+	if opts.Greeting != nil {
+		index = *opts.Greeting
+		if index < 0 || index >= len(greetings) {
+			return nil, fmt.Errorf("%w: %d", ErrGreetingOutOfRange, index)
 		}
+	} else if opts.Seed != nil {
+		index = (*opts.Seed%len(greetings) + len(greetings)) % len(greetings)
 	}
-	log.Debug().Int("entries", entries).Int("lines", lines).Msg("ExampleMessages built")
-	return messages
+	if greetings[index] == "" {
+		return history, nil
+	}
+	log.Debug().Int("index", index).Msg("Greeting injected")
+	greeting := messageType{Role: assistant, Content: greetings[index]}
+	return append([]messageType{greeting}, history...), nil
 }
 
-func (c *cardType) buildMainChat(history []messageType, wi *worldInfoType) []messageType {
-	messages := make([]messageType, 0)
-	c.pushPrompt(&messages, system, c.NewMainChat)
-	entries := lorebookEntriesType{}
-	if wi == nil {
-		return append(messages, history...)
-	}
-	for i, msg := range history {
-		if i == 0 {
-			entries = wi.AtDepth.Depth(len(history), -1)
-			if entries.Len() > 0 {
-				c.applyLorebookEntries(&messages, entries)
-			}
-		}
-		depth := len(history) - i - 1
-		messages = append(messages, msg)
-		entries = wi.AtDepth.Depth(depth, depth)
-		if entries.Len() > 0 {
-			c.applyLorebookEntries(&messages, entries)
-		}
+func (c *cardType) toOpenAIMessages(messages []messageType) []openai.ChatCompletionMessage {
+	openAIMessages := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, msg := range messages {
+		openAIMessages = append(openAIMessages, msg.ToOpenAIMessage())
 	}
-	return messages
+	return openAIMessages
 }
 
 func (c *cardType) pushPrompt(messages *[]messageType, role roleType, prompt string) {
@@ -0,0 +1,83 @@
+package st
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+func TestInjectGreeting(t *testing.T) {
+	data := ccv3.CharacterCardData{
+		FirstMes:           "Hi {{user}}.",
+		AlternateGreetings: []string{"Alt one.", "Alt two."},
+		GroupOnlyGreetings: []string{"Hello everyone."},
+	}
+	index := func(i int) *int { return &i }
+	tests := []struct {
+		name    string
+		history []messageType
+		opts    ApplyOptions
+		want    string // 注入的开场白，为空时不注入
+		wantErr error
+	}{
+		{"first_mes by default", testChat("hello"), ApplyOptions{}, "Hi 用户.", nil},
+		{"greeting index", testChat("hello"), ApplyOptions{Greeting: index(2)}, "Alt two.", nil},
+		{"greeting index wins over seed", testChat("hello"), ApplyOptions{Greeting: index(1), Seed: index(2)}, "Alt one.", nil},
+		{"seed", testChat("hello"), ApplyOptions{Seed: index(5)}, "Alt two.", nil},
+		{"negative seed", testChat("hello"), ApplyOptions{Seed: index(-1)}, "Alt two.", nil},
+		{"group seed", testChat("hello"), ApplyOptions{Seed: index(3), Group: true}, "Hello everyone.", nil},
+		{"group only greeting outside groups", testChat("hello"), ApplyOptions{Greeting: index(3)}, "", ErrGreetingOutOfRange},
+		{"group only greeting", testChat("hello"), ApplyOptions{Greeting: index(3), Group: true}, "Hello everyone.", nil},
+		{"negative index", testChat("hello"), ApplyOptions{Greeting: index(-1)}, "", ErrGreetingOutOfRange},
+		{"history starts with the greeting", testChat("Hi 用户.", "hello"), ApplyOptions{}, "", nil},
+		{"history starts with an edited greeting", testChat("Hi there, edited.", "hello"), ApplyOptions{Greeting: index(1)}, "", nil},
+		{"empty history", nil, ApplyOptions{Greeting: index(1)}, "Alt one.", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, data)
+			got, err := c.injectGreeting(tt.history, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("injectGreeting() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.want == "" {
+				if !slices.Equal(got, tt.history) {
+					t.Errorf("injectGreeting() = %v, want history unchanged", got)
+				}
+				return
+			}
+			if len(got) != len(tt.history)+1 || got[0].Role != assistant || got[0].Content != tt.want {
+				t.Errorf("injectGreeting() = %v, want %q first", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectGreetingEmptyFirstMes(t *testing.T) {
+	c := newTestCard(t, ccv3.CharacterCardData{})
+	history := testChat("hello")
+	got, err := c.injectGreeting(history, ApplyOptions{})
+	if err != nil || !slices.Equal(got, history) {
+		t.Errorf("injectGreeting() = %v, %v, want history unchanged", got, err)
+	}
+}
+
+func TestGetGreetings(t *testing.T) {
+	c := newTestCard(t, ccv3.CharacterCardData{
+		Name:               "Alice",
+		FirstMes:           "  I am {{char}}.\n",
+		AlternateGreetings: []string{"Hey {{user}}"},
+		GroupOnlyGreetings: []string{"Hi all"},
+	})
+	if got, want := c.GetGreetings(false), []string{"I am Alice.", "Hey 用户"}; !slices.Equal(got, want) {
+		t.Errorf("GetGreetings(false) = %q, want %q", got, want)
+	}
+	if got, want := c.GetGreetings(true), []string{"I am Alice.", "Hey 用户", "Hi all"}; !slices.Equal(got, want) {
+		t.Errorf("GetGreetings(true) = %q, want %q", got, want)
+	}
+}
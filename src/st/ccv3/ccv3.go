@@ -70,13 +70,13 @@ type StandaloneLorebook struct {
 
 // Lorebook 定义的一个角色设定集
 type Lorebook struct {
-	Name              string                 `json:"name,omitempty"`               // 设定集名称 (可选)
-	Description       string                 `json:"description,omitempty"`        // 设定集描述 (可选)
-	ScanDepth         int                    `json:"scan_depth,omitempty"`         // 扫描深度 (最近N条消息, 可选)
-	TokenBudget       int                    `json:"token_budget,omitempty"`       // Token预算 (可选)
-	RecursiveScanning bool                   `json:"recursive_scanning,omitempty"` // 是否递归扫描 (可选)
-	Extensions        map[string]interface{} `json:"extensions"`                   // 扩展数据
-	Entries           []LorebookEntry        `json:"entries"`                      // 设定集条目数组
+	Name              string                 `json:"name,omitempty"`         // 设定集名称 (可选)
+	Description       string                 `json:"description,omitempty"`  // 设定集描述 (可选)
+	ScanDepth         int                    `json:"scan_depth,omitempty"`   // 扫描深度 (最近N条消息, 可选)
+	TokenBudget       int                    `json:"token_budget,omitempty"` // Token预算 (可选)
+	RecursiveScanning bool                   `json:"recursive_scanning"`     // 是否递归扫描 (可选, 缺省时为启用)
+	Extensions        map[string]interface{} `json:"extensions"`             // 扩展数据
+	Entries           []LorebookEntry        `json:"entries"`                // 设定集条目数组
 }
 
 // LorebookEntry 定义设定集中的单个条目
@@ -130,6 +130,7 @@ type LorebookEntryExtension struct {
 	MatchScenario             bool                      `json:"match_scenario,omitempty"`
 	MatchCreatorNotes         bool                      `json:"match_creator_notes,omitempty"`
 	Triggers                  []interface{}             `json:"triggers,omitempty"`
+	IgnoreBudget              bool                      `json:"ignore_budget,omitempty"` // 激活时不受 token 预算限制
 }
 
 // LorebookInsertionPosition 定义设定集条目的插入位置
@@ -177,3 +178,26 @@ const (
 	*/
 	LorebookInsertionAfterExampleMessages
 )
+
+// SelectiveLogic 定义主关键词匹配后，次关键词 (secondary_keys) 的匹配规则
+const (
+	SelectiveLogicAndAny = iota // 任一次关键词匹配
+	SelectiveLogicNotAll        // 并非所有次关键词都匹配
+	SelectiveLogicNotAny        // 没有次关键词匹配
+	SelectiveLogicAndAll        // 所有次关键词都匹配
+)
+
+// 生成类型，条目的 triggers 为空时在所有生成类型下激活，否则仅在列出的生成类型下激活
+const (
+	TriggerNormal      = "normal"      // 正常生成
+	TriggerContinue    = "continue"    // 继续生成
+	TriggerImpersonate = "impersonate" // 代替用户生成
+	TriggerSwipe       = "swipe"       // 生成新的候选回复
+	TriggerRegenerate  = "regenerate"  // 重新生成
+	TriggerQuiet       = "quiet"       // 后台生成
+)
+
+// Triggers 返回所有生成类型
+func Triggers() []string {
+	return []string{TriggerNormal, TriggerContinue, TriggerImpersonate, TriggerSwipe, TriggerRegenerate, TriggerQuiet}
+}
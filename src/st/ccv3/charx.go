@@ -0,0 +1,174 @@
+package ccv3
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var zipSignature = []byte("PK\x03\x04")
+
+// IsCHARX 判断数据是否为 CHARX (zip) 压缩包
+func IsCHARX(data []byte) bool {
+	return bytes.HasPrefix(data, zipSignature)
+}
+
+// AssetFiles 保存角色卡的内嵌资源文件，键为压缩包内路径 (如 assets/icon/images/main.png)
+type AssetFiles map[string][]byte
+
+// CHARX 压缩包解压后的大小限制，用于防止压缩炸弹
+const (
+	MaxCHARXFileSize  = 32 << 20  // 单个文件的最大字节数
+	MaxCHARXTotalSize = 256 << 20 // card.json 与全部资源文件的最大总字节数
+)
+
+// ErrCHARXTooLarge 表示 CHARX 压缩包解压后超出大小限制
+var ErrCHARXTooLarge = errors.New("CHARX content too large")
+
+// ReadCHARX 读取 CHARX 压缩包，返回 card.json 的内容与 assets/ 下的资源文件，
+// 解压后超出 MaxCHARXFileSize 或 MaxCHARXTotalSize 时返回 ErrCHARXTooLarge
+func ReadCHARX(data []byte) ([]byte, AssetFiles, error) {
+	return readCHARX(data, MaxCHARXFileSize, MaxCHARXTotalSize)
+}
+
+func readCHARX(data []byte, fileLimit int64, totalLimit int64) ([]byte, AssetFiles, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	var card []byte
+	files := make(AssetFiles)
+	remaining := totalLimit
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Clean(f.Name)
+		if name != CHARXCardPath && !strings.HasPrefix(name, "assets/") {
+			continue
+		}
+		content, err := readZipFile(f, min(fileLimit, remaining))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		remaining -= int64(len(content))
+		if name == CHARXCardPath {
+			card = content
+		} else {
+			files[name] = content
+		}
+	}
+	if card == nil {
+		return nil, nil, fmt.Errorf("%s not found in CHARX", CHARXCardPath)
+	}
+	return card, files, nil
+}
+
+// ReadCHARXCard 仅读取 CHARX 压缩包中的 card.json，解压后超出 MaxCHARXFileSize 时返回 ErrCHARXTooLarge
+func ReadCHARXCard(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || path.Clean(f.Name) != CHARXCardPath {
+			continue
+		}
+		card, err := readZipFile(f, MaxCHARXFileSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		return card, nil
+	}
+	return nil, fmt.Errorf("%s not found in CHARX", CHARXCardPath)
+}
+
+// ReadCardJSON 从 JSON、PNG 图片或 CHARX 压缩包中读取角色卡 JSON
+func ReadCardJSON(data []byte) ([]byte, error) {
+	if IsPNG(data) {
+		extracted, err := ReadPNG(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PNG: %w", err)
+		}
+		return extracted, nil
+	}
+	if IsCHARX(data) {
+		extracted, err := ReadCHARXCard(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CHARX: %w", err)
+		}
+		return extracted, nil
+	}
+	return data, nil
+}
+
+// Resolve 返回资源 URI 对应的内容，支持 embeded://、ccdefault: 与 data: URI。
+// defaultIcon 为角色卡的 PNG 图片，用于解析 ccdefault: 图标。
+func (f AssetFiles) Resolve(asset Asset, defaultIcon []byte) ([]byte, error) {
+	uri := asset.URI
+	switch {
+	case strings.HasPrefix(uri, AssetURIEmbedded), strings.HasPrefix(uri, AssetURIEmbeddedAlt):
+		name := strings.TrimPrefix(strings.TrimPrefix(uri, AssetURIEmbedded), AssetURIEmbeddedAlt)
+		data, ok := f[path.Clean(name)]
+		if !ok {
+			return nil, fmt.Errorf("embedded asset not found: %s", name)
+		}
+		return data, nil
+	case strings.HasPrefix(uri, AssetURIDefault):
+		if asset.Type != "icon" || len(defaultIcon) == 0 {
+			return nil, fmt.Errorf("no default asset for type %s", asset.Type)
+		}
+		return defaultIcon, nil
+	case strings.HasPrefix(uri, AssetURIData):
+		return decodeDataURI(uri)
+	default:
+		return nil, fmt.Errorf("unsupported asset URI: %s", uri)
+	}
+}
+
+// DefaultIcon 返回 CHARX 角色卡用于解析 ccdefault: 的默认图标，
+// 优先使用名为 main 的内嵌图标，其次为第一个可解析的图标
+func (f AssetFiles) DefaultIcon(assets []Asset) []byte {
+	var icon []byte
+	for _, asset := range assets {
+		if asset.Type != "icon" || strings.HasPrefix(asset.URI, AssetURIDefault) {
+			continue
+		}
+		data, err := f.Resolve(asset, nil)
+		if err != nil {
+			continue
+		}
+		if asset.Name == "main" {
+			return data
+		}
+		if icon == nil {
+			icon = data
+		}
+	}
+	return icon
+}
+
+// readZipFile 读取压缩包内的文件，解压后超出 limit 字节时返回 ErrCHARXTooLarge
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	// The declared size is untrusted, but lets oversized files fail before decompressing
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrCHARXTooLarge, f.UncompressedSize64)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrCHARXTooLarge, limit)
+	}
+	return content, nil
+}
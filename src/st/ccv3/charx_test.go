@@ -0,0 +1,192 @@
+package ccv3
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testCHARX 返回包含给定文件的 zip 压缩包
+func testCHARX(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadCHARX(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantFiles []string
+		wantErr   bool
+	}{
+		{
+			"card and assets",
+			map[string]string{"card.json": "{}", "assets/icon/images/main.png": "PNG", "./assets/x/../other/a.txt": "A", "readme.txt": "skip"},
+			[]string{"assets/icon/images/main.png", "assets/other/a.txt"}, false,
+		},
+		{"missing card.json", map[string]string{"assets/icon/images/main.png": "PNG"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testCHARX(t, tt.files)
+			if !IsCHARX(data) {
+				t.Fatal("IsCHARX() = false")
+			}
+			card, files, err := ReadCHARX(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCHARX() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(card) != "{}" {
+				t.Errorf("card = %q, want {}", card)
+			}
+			if len(files) != len(tt.wantFiles) {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+			for _, name := range tt.wantFiles {
+				if _, ok := files[name]; !ok {
+					t.Errorf("missing file %s", name)
+				}
+			}
+		})
+	}
+	if _, _, err := ReadCHARX([]byte("PK\x03\x04broken")); err == nil {
+		t.Error("ReadCHARX() accepted a broken archive")
+	}
+}
+
+func TestReadCHARXLimits(t *testing.T) {
+	const fileLimit, totalLimit = 16, 32
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{"within limits", map[string]string{"card.json": "{}", "assets/a": strings.Repeat("a", 16)}, false},
+		{"card too large", map[string]string{"card.json": strings.Repeat(" ", 17)}, true},
+		{"asset too large", map[string]string{"card.json": "{}", "assets/a": strings.Repeat("a", 17)}, true},
+		{"total too large", map[string]string{"card.json": "{}", "assets/a": strings.Repeat("a", 12), "assets/b": strings.Repeat("b", 12), "assets/c": strings.Repeat("c", 12)}, true},
+		{"skipped files are not counted", map[string]string{"card.json": "{}", "readme.txt": strings.Repeat("r", 64)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readCHARX(testCHARX(t, tt.files), fileLimit, totalLimit)
+			if tt.wantErr && !errors.Is(err, ErrCHARXTooLarge) {
+				t.Fatalf("readCHARX() error = %v, want ErrCHARXTooLarge", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("readCHARX() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestReadCHARXCard(t *testing.T) {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(CHARXCardPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("{}"))
+	// An asset that claims to decompress to 1 TiB
+	w, err = zw.CreateRaw(&zip.FileHeader{Name: "assets/bomb", Method: zip.Deflate, CompressedSize64: 4, UncompressedSize64: 1 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("junk"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	card, err := ReadCHARXCard(buf.Bytes())
+	if err != nil || string(card) != "{}" {
+		t.Errorf("ReadCHARXCard() = %q, %v, want {}", card, err)
+	}
+	if card, err := ReadCardJSON(buf.Bytes()); err != nil || string(card) != "{}" {
+		t.Errorf("ReadCardJSON() = %q, %v, want {}", card, err)
+	}
+	if _, _, err := ReadCHARX(buf.Bytes()); !errors.Is(err, ErrCHARXTooLarge) {
+		t.Errorf("ReadCHARX() error = %v, want ErrCHARXTooLarge", err)
+	}
+	if _, err := ReadCHARXCard(testCHARX(t, map[string]string{"assets/a": "A"})); err == nil {
+		t.Error("ReadCHARXCard() accepted an archive without card.json")
+	}
+}
+
+func TestAssetFilesResolve(t *testing.T) {
+	files := AssetFiles{"assets/icon/images/main.png": []byte("MAIN")}
+	tests := []struct {
+		name    string
+		asset   Asset
+		icon    []byte
+		want    string
+		wantErr bool
+	}{
+		{"embedded", Asset{Type: "icon", URI: "embeded://assets/icon/images/main.png"}, nil, "MAIN", false},
+		{"embedded alternative spelling", Asset{Type: "icon", URI: "embedded://assets/icon/images/main.png"}, nil, "MAIN", false},
+		{"embedded missing", Asset{Type: "icon", URI: "embeded://assets/icon/images/x.png"}, nil, "", true},
+		{"default icon", Asset{Type: "icon", URI: "ccdefault:"}, []byte("ICON"), "ICON", false},
+		{"default icon missing", Asset{Type: "icon", URI: "ccdefault:"}, nil, "", true},
+		{"default background", Asset{Type: "background", URI: "ccdefault:"}, []byte("ICON"), "", true},
+		{"data URI", Asset{Type: "icon", URI: "data:text/plain;base64,SGk="}, nil, "Hi", false},
+		{"plain data URI", Asset{Type: "icon", URI: "data:text/plain,Hi"}, nil, "Hi", false},
+		{"remote", Asset{Type: "icon", URI: "https://example.com/a.png"}, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := files.Resolve(tt.asset, tt.icon)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssetFilesDefaultIcon(t *testing.T) {
+	files := AssetFiles{
+		"assets/icon/images/main.png": []byte("MAIN"),
+		"assets/icon/images/alt.png":  []byte("ALT"),
+	}
+	icon := func(name string, uri string) Asset {
+		return Asset{Type: "icon", URI: uri, Name: name, Ext: "png"}
+	}
+	tests := []struct {
+		name   string
+		assets []Asset
+		want   string
+	}{
+		{"main icon", []Asset{icon("alt", "embeded://assets/icon/images/alt.png"), icon("main", "embeded://assets/icon/images/main.png")}, "MAIN"},
+		{"first icon", []Asset{icon("default", "ccdefault:"), icon("alt", "embeded://assets/icon/images/alt.png")}, "ALT"},
+		{"unresolvable icons", []Asset{icon("main", "embeded://assets/icon/images/x.png")}, ""},
+		{"no icons", []Asset{{Type: "background", URI: "embeded://assets/icon/images/main.png", Name: "main"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := files.DefaultIcon(tt.assets); string(got) != tt.want {
+				t.Errorf("DefaultIcon() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package ccv3
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// 角色卡规范标识
+const (
+	SpecV2        = "chara_card_v2"
+	SpecVersionV2 = "2.0"
+	SpecV3        = "chara_card_v3"
+	SpecVersionV3 = "3.0"
+)
+
+// 资源URI前缀
+const (
+	AssetURIEmbedded    = "embeded://"
+	AssetURIEmbeddedAlt = "embedded://" // 部分工具使用正确拼写
+	AssetURIDefault     = "ccdefault:"
+	AssetURIData        = "data:"
+)
+
+// CHARXCardPath 是 CHARX 压缩包中角色卡 JSON 的路径
+const CHARXCardPath = "card.json"
+
+// WriteJSON 将角色卡序列化为 JSON
+func WriteJSON(w io.Writer, card CharacterCard) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(card)
+}
+
+// WritePNG 将角色卡写入 PNG 图片，同时写入 chara (V2 兼容) 与 ccv3 两个 tEXt 块。
+// img 为空时使用一张空白图片。
+func WritePNG(w io.Writer, img []byte, card CharacterCard) error {
+	if len(img) == 0 {
+		blank := bytes.Buffer{}
+		if err := png.Encode(&blank, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+			return err
+		}
+		img = blank.Bytes()
+	}
+	chunks, err := readPNGChunks(img)
+	if err != nil {
+		return err
+	}
+
+	v2 := CharacterCard{
+		Spec:        SpecV2,
+		SpecVersion: SpecVersionV2,
+		Data:        card.Data,
+	}
+	v2Text, err := json.Marshal(v2)
+	if err != nil {
+		return err
+	}
+	v3Text, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	cardChunks := []pngChunk{
+		newPNGTextChunk(PNGKeywordV2, base64.StdEncoding.EncodeToString(v2Text)),
+		newPNGTextChunk(PNGKeywordV3, base64.StdEncoding.EncodeToString(v3Text)),
+	}
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+	ended := false
+	for _, chunk := range chunks {
+		if chunk.Type == "tEXt" {
+			// Drop the existing character data
+			keyword, _, _ := bytes.Cut(chunk.Data, []byte{0})
+			if string(keyword) == PNGKeywordV2 || string(keyword) == PNGKeywordV3 {
+				continue
+			}
+		}
+		if chunk.Type == "IEND" {
+			ended = true
+			for _, cardChunk := range cardChunks {
+				if err := writePNGChunk(w, cardChunk); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writePNGChunk(w, chunk); err != nil {
+			return err
+		}
+	}
+	// Images without an IEND chunk still get the character data and a proper end
+	if !ended {
+		for _, chunk := range append(cardChunks, pngChunk{Type: "IEND"}) {
+			if err := writePNGChunk(w, chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteCHARX 将角色卡与资源文件打包为 CHARX 压缩包。
+// files 为压缩包内已有的资源文件，键为路径 (如 assets/icon/images/main.png)。
+// 使用 data: URI 的资源会被解码并以 embeded:// URI 内嵌到压缩包中。
+func WriteCHARX(w io.Writer, card CharacterCard, files AssetFiles) error {
+	embedded := make(AssetFiles, len(files))
+	for name, data := range files {
+		embedded[name] = data
+	}
+	assets := make([]Asset, 0, len(card.Data.Assets))
+	for _, asset := range card.Data.Assets {
+		if strings.HasPrefix(asset.URI, AssetURIData) {
+			data, err := decodeDataURI(asset.URI)
+			if err != nil {
+				return fmt.Errorf("failed to decode asset %s: %w", asset.Name, err)
+			}
+			name := uniqueAssetPath(charxAssetPath(asset), embedded)
+			embedded[name] = data
+			asset.URI = AssetURIEmbedded + name
+		}
+		assets = append(assets, asset)
+	}
+	card.Data.Assets = assets
+
+	zw := zip.NewWriter(w)
+	cw, err := zw.Create(CHARXCardPath)
+	if err != nil {
+		return err
+	}
+	if err := WriteJSON(cw, card); err != nil {
+		return err
+	}
+	for name, data := range embedded {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func newPNGTextChunk(keyword, text string) pngChunk {
+	return pngChunk{
+		Type: "tEXt",
+		Data: append([]byte(keyword+"\x00"), text...),
+	}
+}
+
+func writePNGChunk(w io.Writer, chunk pngChunk) error {
+	buf := make([]byte, 0, len(chunk.Data)+12)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(chunk.Data)))
+	buf = append(buf, chunk.Type...)
+	buf = append(buf, chunk.Data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := w.Write(buf)
+	return err
+}
+
+// decodeDataURI 解码 base64 编码的 data: URI
+func decodeDataURI(uri string) ([]byte, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(uri, AssetURIData), ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URI")
+	}
+	if !strings.HasSuffix(meta, ";base64") {
+		return []byte(data), nil
+	}
+	return decodeBase64(data)
+}
+
+// charxAssetPath 返回资源在 CHARX 压缩包中的推荐路径，类型、名称与扩展名仅保留安全字符
+func charxAssetPath(asset Asset) string {
+	category := "other"
+	ext := sanitizeAssetName(asset.Ext, "")
+	switch strings.ToLower(ext) {
+	case "png", "jpg", "jpeg", "webp", "gif", "avif":
+		category = "images"
+	case "mp3", "wav", "ogg", "flac", "m4a":
+		category = "audio"
+	case "mp4", "webm", "mkv":
+		category = "video"
+	}
+	name := sanitizeAssetName(asset.Name, "asset")
+	if ext != "" {
+		name += "." + ext
+	}
+	return path.Join("assets", sanitizeAssetName(asset.Type, "other"), category, name)
+}
+
+// sanitizeAssetName 将 s 转换为可用作单个路径段的名称，
+// 仅保留字母、数字、-、_ 与 .，去除开头的 .，结果为空时返回 fallback
+func sanitizeAssetName(s string, fallback string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+	s = strings.TrimLeft(s, ".")
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// uniqueAssetPath 在 name 已存在于 files 中时，在扩展名前添加 -2、-3 等后缀
+func uniqueAssetPath(name string, files AssetFiles) string {
+	if _, ok := files[name]; !ok {
+		return name
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, ok := files[candidate]; !ok {
+			return candidate
+		}
+	}
+}
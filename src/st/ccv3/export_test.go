@@ -0,0 +1,227 @@
+package ccv3
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testCard() CharacterCard {
+	return CharacterCard{
+		Spec:        SpecV3,
+		SpecVersion: SpecVersionV3,
+		Data: CharacterCardData{
+			Name:               "Alice",
+			Description:        "A curious girl.",
+			FirstMes:           "你好！",
+			AlternateGreetings: []string{"Hi."},
+			Tags:               []string{},
+			GroupOnlyGreetings: []string{},
+			CharacterBook: &Lorebook{
+				RecursiveScanning: false,
+				Extensions:        map[string]interface{}{},
+				Entries:           []LorebookEntry{{Keys: []string{"rabbit"}, Content: "A white rabbit.", Enabled: true}},
+			},
+		},
+	}
+}
+
+// roundTrip 从导出的数据中读取并解析角色卡
+func roundTrip(t *testing.T, data []byte) CharacterCard {
+	t.Helper()
+	raw, err := ReadCardJSON(data)
+	if err != nil {
+		t.Fatalf("ReadCardJSON() error = %v", err)
+	}
+	card, spec, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if spec != SpecV3 {
+		t.Errorf("spec = %q, want %q", spec, SpecV3)
+	}
+	return card
+}
+
+func assertSameCard(t *testing.T, got CharacterCard, want CharacterCard) {
+	t.Helper()
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("round trip changed the card:\n got %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *bytes.Buffer, card CharacterCard) error
+	}{
+		{"json", func(w *bytes.Buffer, card CharacterCard) error { return WriteJSON(w, card) }},
+		{"png", func(w *bytes.Buffer, card CharacterCard) error { return WritePNG(w, nil, card) }},
+		{"charx", func(w *bytes.Buffer, card CharacterCard) error { return WriteCHARX(w, card, nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := tt.write(&buf, testCard()); err != nil {
+				t.Fatal(err)
+			}
+			assertSameCard(t, roundTrip(t, buf.Bytes()), testCard())
+		})
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	first := bytes.Buffer{}
+	if err := WritePNG(&first, nil, testCard()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(first.Bytes())); err != nil {
+		t.Fatalf("exported PNG is not decodable: %v", err)
+	}
+
+	// Writing into an exported image replaces the character data
+	updated := testCard()
+	updated.Data.Name = "Bob"
+	second := bytes.Buffer{}
+	if err := WritePNG(&second, first.Bytes(), updated); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(second.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	texts := make(map[string]string)
+	for _, chunk := range chunks {
+		if keyword, text, ok := bytes.Cut(chunk.Data, []byte{0}); ok && chunk.Type == "tEXt" {
+			counts[string(keyword)]++
+			texts[string(keyword)] = string(text)
+		}
+	}
+	if counts[PNGKeywordV2] != 1 || counts[PNGKeywordV3] != 1 {
+		t.Errorf("tEXt chunks = %v, want one chara and one ccv3", counts)
+	}
+	assertSameCard(t, roundTrip(t, second.Bytes()), updated)
+
+	// Readers without V3 support fall back to the chara chunk
+	v2, err := decodeBase64(texts[PNGKeywordV2])
+	if err != nil || !strings.Contains(string(v2), `"spec":"chara_card_v2"`) {
+		t.Errorf("chara chunk = %s, %v", v2, err)
+	}
+}
+
+func TestWriteCHARXEmbedsDataURIs(t *testing.T) {
+	card := testCard()
+	card.Data.Assets = []Asset{
+		{Type: "icon", URI: "data:image/png;base64,UE5H", Name: "main", Ext: "png"},
+		{Type: "background", URI: "https://example.com/bg.png", Name: "bg", Ext: "png"},
+	}
+	buf := bytes.Buffer{}
+	if err := WriteCHARX(&buf, card, AssetFiles{"assets/other/other/notes.txt": []byte("notes")}); err != nil {
+		t.Fatal(err)
+	}
+	raw, files, err := ReadCHARX(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"embedded icon URI", exported.Data.Assets[0].URI, "embeded://assets/icon/images/main.png"},
+		{"embedded icon content", string(files["assets/icon/images/main.png"]), "PNG"},
+		{"remote URI kept", exported.Data.Assets[1].URI, "https://example.com/bg.png"},
+		{"existing files kept", string(files["assets/other/other/notes.txt"]), "notes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritePNGWithoutIEND(t *testing.T) {
+	img := bytes.Buffer{}
+	if err := WritePNG(&img, nil, testCard()); err != nil {
+		t.Fatal(err)
+	}
+	// Drop the trailing IEND chunk (12 bytes with an empty body)
+	truncated := img.Bytes()[:img.Len()-12]
+	out := bytes.Buffer{}
+	if err := WritePNG(&out, truncated, testCard()); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := chunks[len(chunks)-1]; last.Type != "IEND" {
+		t.Errorf("last chunk = %s, want IEND", last.Type)
+	}
+	assertSameCard(t, roundTrip(t, out.Bytes()), testCard())
+}
+
+func TestCHARXAssetPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		asset Asset
+		want  string
+	}{
+		{"image", Asset{Type: "icon", Name: "main", Ext: "png"}, "assets/icon/images/main.png"},
+		{"audio", Asset{Type: "sound", Name: "theme", Ext: "MP3"}, "assets/sound/audio/theme.MP3"},
+		{"traversal name", Asset{Type: "icon", Name: "../../x", Ext: "png"}, "assets/icon/images/_.._x.png"},
+		{"traversal type", Asset{Type: "..", Name: "a", Ext: "png"}, "assets/other/images/a.png"},
+		{"slashes in ext", Asset{Type: "icon", Name: "a", Ext: "png/../../b"}, "assets/icon/other/a.png_.._.._b"},
+		{"empty name", Asset{Type: "icon", Ext: "png"}, "assets/icon/images/asset.png"},
+		{"no ext", Asset{Type: "x-risu-asset", Name: "data"}, "assets/x-risu-asset/other/data"},
+		{"unicode name", Asset{Type: "emotion", Name: "开心 脸", Ext: "webp"}, "assets/emotion/images/开心_脸.webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := charxAssetPath(tt.asset); got != tt.want {
+				t.Errorf("charxAssetPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCHARXDeduplicatesAssets(t *testing.T) {
+	card := testCard()
+	card.Data.Assets = []Asset{
+		{Type: "emotion", URI: "data:text/plain,one", Name: "smile", Ext: "png"},
+		{Type: "emotion", URI: "data:text/plain,two", Name: "smile", Ext: "png"},
+		{Type: "emotion", URI: "data:text/plain,three", Name: "smile", Ext: "png"},
+	}
+	buf := bytes.Buffer{}
+	if err := WriteCHARX(&buf, card, nil); err != nil {
+		t.Fatal(err)
+	}
+	raw, files, err := ReadCHARX(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"one", "two", "three"} {
+		data, err := files.Resolve(exported.Data.Assets[i], nil)
+		if err != nil || string(data) != want {
+			t.Errorf("asset %d (%s) = %q, %v, want %q", i, exported.Data.Assets[i].URI, data, err, want)
+		}
+	}
+	if uri := exported.Data.Assets[1].URI; uri != "embeded://assets/emotion/images/smile-2.png" {
+		t.Errorf("second asset URI = %q", uri)
+	}
+}
@@ -0,0 +1,38 @@
+package ccv3
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SpecLorebookV3 是独立设定集文件的规范标识
+const SpecLorebookV3 = "lorebook_v3"
+
+// UnmarshalJSON 解析设定集，recursive_scanning 缺省时视为启用
+func (b *Lorebook) UnmarshalJSON(data []byte) error {
+	type lorebook Lorebook
+	book := lorebook{RecursiveScanning: true}
+	if err := json.Unmarshal(data, &book); err != nil {
+		return err
+	}
+	*b = Lorebook(book)
+	return nil
+}
+
+// ParseLorebook 解析独立设定集文件，支持 lorebook_v3 与 SillyTavern World Info 格式
+func ParseLorebook(data []byte) (Lorebook, error) {
+	if isSillyTavernWorldInfo(data) {
+		return ParseSillyTavernWorldInfo(data)
+	}
+	book := StandaloneLorebook{}
+	if err := json.Unmarshal(data, &book); err != nil {
+		return Lorebook{}, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	if book.Spec != SpecLorebookV3 {
+		return Lorebook{}, fmt.Errorf("unsupported spec: %s", book.Spec)
+	}
+	if book.Data.Extensions == nil {
+		book.Data.Extensions = map[string]interface{}{}
+	}
+	return book.Data, nil
+}
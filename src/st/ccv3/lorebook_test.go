@@ -0,0 +1,41 @@
+package ccv3
+
+import (
+	"testing"
+)
+
+func TestParseLorebook(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wantEntries   int
+		wantRecursive bool
+		wantErr       bool
+	}{
+		{"lorebook_v3", `{"spec":"lorebook_v3","data":{"name":"Book","entries":[{"keys":["a"],"content":"A","enabled":true}]}}`, 1, true, false},
+		{"recursion disabled", `{"spec":"lorebook_v3","data":{"recursive_scanning":false,"entries":[]}}`, 0, false, false},
+		{"SillyTavern World Info", `{"entries":{"0":{"uid":0,"key":["a"],"content":"A"}}}`, 1, true, false},
+		{"unsupported spec", `{"spec":"chara_card_v3","data":{"entries":[]}}`, 0, false, true},
+		{"invalid JSON", `{"spec":`, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book, err := ParseLorebook([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLorebook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(book.Entries) != tt.wantEntries {
+				t.Errorf("len(Entries) = %d, want %d", len(book.Entries), tt.wantEntries)
+			}
+			if book.RecursiveScanning != tt.wantRecursive {
+				t.Errorf("RecursiveScanning = %v, want %v", book.RecursiveScanning, tt.wantRecursive)
+			}
+			if book.Extensions == nil {
+				t.Error("Extensions must not be nil")
+			}
+		})
+	}
+}
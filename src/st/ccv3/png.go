@@ -0,0 +1,95 @@
+package ccv3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// PNG 中保存角色卡数据的 tEXt 块关键字
+const (
+	PNGKeywordV3 = "ccv3"  // V3 角色卡
+	PNGKeywordV2 = "chara" // V2 及更早版本的角色卡
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// IsPNG 判断数据是否为 PNG 图片
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// ReadPNG 从 PNG 图片的 tEXt 块中提取角色卡 JSON，优先使用 ccv3 块，其次为 chara 块
+func ReadPNG(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	texts := make(map[string]string)
+	for _, chunk := range chunks {
+		if chunk.Type != "tEXt" {
+			continue
+		}
+		keyword, text, ok := bytes.Cut(chunk.Data, []byte{0})
+		if !ok {
+			continue
+		}
+		texts[string(keyword)] = string(text)
+	}
+	for _, keyword := range []string{PNGKeywordV3, PNGKeywordV2} {
+		text, ok := texts[keyword]
+		if !ok {
+			continue
+		}
+		decoded, err := decodeBase64(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s chunk: %w", keyword, err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("no character data found in PNG")
+}
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !IsPNG(data) {
+		return nil, fmt.Errorf("invalid PNG signature")
+	}
+	chunks := make([]pngChunk, 0)
+	data = data[len(pngSignature):]
+	for len(data) > 0 {
+		// length(4) + type(4) + data(length) + crc(4)
+		if len(data) < 12 {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		length := binary.BigEndian.Uint32(data[:4])
+		if uint64(length)+12 > uint64(len(data)) {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		chunk := pngChunk{
+			Type: string(data[4:8]),
+			Data: data[8 : 8+length],
+		}
+		chunks = append(chunks, chunk)
+		data = data[12+length:]
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if decoded, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return decoded, nil
+	}
+	// Some writers omit the padding
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
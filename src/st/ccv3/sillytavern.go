@@ -0,0 +1,167 @@
+package ccv3
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SillyTavernWorldInfo 是 SillyTavern 导出的 World Info 文件结构
+type SillyTavernWorldInfo struct {
+	Name    string                               `json:"name,omitempty"` // 设定集名称 (可选)
+	Entries map[string]SillyTavernWorldInfoEntry `json:"entries"`        // 以 uid 为键的条目
+}
+
+// SillyTavernWorldInfoEntry 是 SillyTavern World Info 中的单个条目
+type SillyTavernWorldInfoEntry struct {
+	UID                       int                       `json:"uid"`
+	Key                       []string                  `json:"key"`
+	KeySecondary              []string                  `json:"keysecondary"`
+	Comment                   string                    `json:"comment"`
+	Content                   string                    `json:"content"`
+	Constant                  bool                      `json:"constant"`
+	Vectorized                bool                      `json:"vectorized"`
+	Selective                 bool                      `json:"selective"`
+	SelectiveLogic            int                       `json:"selectiveLogic"`
+	Order                     int                       `json:"order"`
+	Position                  LorebookInsertionPosition `json:"position"`
+	Disable                   bool                      `json:"disable"`
+	ExcludeRecursion          bool                      `json:"excludeRecursion"`
+	PreventRecursion          bool                      `json:"preventRecursion"`
+	DelayUntilRecursion       any                       `json:"delayUntilRecursion"`
+	DisplayIndex              int                       `json:"displayIndex"`
+	Probability               *int                      `json:"probability"`
+	UseProbability            bool                      `json:"useProbability"`
+	Depth                     int                       `json:"depth"`
+	Group                     string                    `json:"group"`
+	GroupOverride             bool                      `json:"groupOverride"`
+	GroupWeight               *int                      `json:"groupWeight"`
+	ScanDepth                 *int                      `json:"scanDepth"`
+	CaseSensitive             *bool                     `json:"caseSensitive"`
+	MatchWholeWords           *bool                     `json:"matchWholeWords"`
+	UseGroupScoring           *bool                     `json:"useGroupScoring"`
+	AutomationId              string                    `json:"automationId"`
+	Role                      *Role                     `json:"role"`
+	Sticky                    *int                      `json:"sticky"`
+	Cooldown                  *int                      `json:"cooldown"`
+	Delay                     *int                      `json:"delay"`
+	MatchPersonaDescription   bool                      `json:"matchPersonaDescription"`
+	MatchCharacterDescription bool                      `json:"matchCharacterDescription"`
+	MatchCharacterPersonality bool                      `json:"matchCharacterPersonality"`
+	MatchCharacterDepthPrompt bool                      `json:"matchCharacterDepthPrompt"`
+	MatchScenario             bool                      `json:"matchScenario"`
+	MatchCreatorNotes         bool                      `json:"matchCreatorNotes"`
+	Triggers                  []interface{}             `json:"triggers"`
+	IgnoreBudget              bool                      `json:"ignoreBudget"`
+}
+
+// isSillyTavernWorldInfo 判断数据是否为 SillyTavern World Info 文件 (entries 为对象)
+func isSillyTavernWorldInfo(data []byte) bool {
+	probe := struct {
+		Entries json.RawMessage `json:"entries"`
+	}{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	for _, b := range probe.Entries {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b == '{'
+	}
+	return false
+}
+
+// ParseSillyTavernWorldInfo 将 SillyTavern World Info 文件转换为 Lorebook
+func ParseSillyTavernWorldInfo(data []byte) (Lorebook, error) {
+	wi := SillyTavernWorldInfo{}
+	if err := json.Unmarshal(data, &wi); err != nil {
+		return Lorebook{}, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	return wi.ToLorebook(), nil
+}
+
+// ToLorebook 将 SillyTavern World Info 转换为 Lorebook，条目按 uid 排序
+func (wi SillyTavernWorldInfo) ToLorebook() Lorebook {
+	entries := make([]SillyTavernWorldInfoEntry, 0, len(wi.Entries))
+	for _, entry := range wi.Entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UID < entries[j].UID
+	})
+
+	book := Lorebook{
+		Name:              wi.Name,
+		RecursiveScanning: true,
+		Extensions:        map[string]interface{}{},
+		Entries:           make([]LorebookEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		book.Entries = append(book.Entries, entry.ToLorebookEntry())
+	}
+	return book
+}
+
+// ToLorebookEntry 将 SillyTavern World Info 条目转换为 LorebookEntry
+func (e SillyTavernWorldInfoEntry) ToLorebookEntry() LorebookEntry {
+	entry := LorebookEntry{
+		Keys:           e.Key,
+		Content:        e.Content,
+		Enabled:        !e.Disable,
+		InsertionOrder: e.Order,
+		Constant:       e.Constant,
+		ID:             e.UID,
+		Comment:        e.Comment,
+		Name:           e.Comment,
+		Selective:      e.Selective,
+		SecondaryKeys:  e.KeySecondary,
+		Extensions: LorebookEntryExtension{
+			Position:                  e.Position,
+			ExcludeRecursion:          e.ExcludeRecursion,
+			DisplayIndex:              e.DisplayIndex,
+			Probability:               valueOr(e.Probability, 100),
+			UseProbability:            e.UseProbability,
+			Depth:                     e.Depth,
+			SelectiveLogic:            e.SelectiveLogic,
+			Group:                     e.Group,
+			GroupOverride:             e.GroupOverride,
+			GroupWeight:               valueOr(e.GroupWeight, 100),
+			PreventRecursion:          e.PreventRecursion,
+			DelayUntilRecursion:       e.DelayUntilRecursion,
+			ScanDepth:                 valueOr(e.ScanDepth, 0),
+			MatchWholeWords:           e.MatchWholeWords,
+			UseGroupScoring:           valueOr(e.UseGroupScoring, false),
+			CaseSensitive:             e.CaseSensitive,
+			AutomationId:              e.AutomationId,
+			Role:                      valueOr(e.Role, RoleSystem),
+			Vectorized:                e.Vectorized,
+			Sticky:                    valueOr(e.Sticky, 0),
+			Cooldown:                  valueOr(e.Cooldown, 0),
+			Delay:                     valueOr(e.Delay, 0),
+			MatchPersonaDescription:   e.MatchPersonaDescription,
+			MatchCharacterDescription: e.MatchCharacterDescription,
+			MatchCharacterPersonality: e.MatchCharacterPersonality,
+			MatchCharacterDepthPrompt: e.MatchCharacterDepthPrompt,
+			MatchScenario:             e.MatchScenario,
+			MatchCreatorNotes:         e.MatchCreatorNotes,
+			Triggers:                  e.Triggers,
+			IgnoreBudget:              e.IgnoreBudget,
+		},
+	}
+	if entry.Keys == nil {
+		entry.Keys = []string{}
+	}
+	if e.CaseSensitive != nil {
+		entry.CaseSensitive = *e.CaseSensitive
+	}
+	return entry
+}
+
+func valueOr[T any](v *T, def T) T {
+	if v == nil {
+		return def
+	}
+	return *v
+}
@@ -0,0 +1,77 @@
+package ccv3
+
+import (
+	"testing"
+)
+
+func TestParseSillyTavernWorldInfo(t *testing.T) {
+	data := `{"name":"World","entries":{
+		"2":{"uid":2,"key":["b"],"content":"B","order":5,"disable":true,"position":4,"depth":2,"role":1,
+			"sticky":3,"cooldown":null,"triggers":["continue"],"ignoreBudget":true},
+		"0":{"uid":0,"key":null,"keysecondary":["x"],"comment":"first","content":"A","selective":true,"selectiveLogic":3,
+			"caseSensitive":true,"probability":null,"groupWeight":null,"delayUntilRecursion":2}
+	}}`
+	book, err := ParseLorebook([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "World" || !book.RecursiveScanning || len(book.Entries) != 2 {
+		t.Fatalf("ParseLorebook() = %+v", book)
+	}
+
+	first, second := book.Entries[0], book.Entries[1]
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"sorted by uid", first.ID, 0},
+		{"comment as name", first.Name, "first"},
+		{"nil keys", len(first.Keys), 0},
+		{"secondary keys", first.SecondaryKeys[0], "x"},
+		{"selective logic", first.Extensions.SelectiveLogic, SelectiveLogicAndAll},
+		{"case sensitive", first.CaseSensitive, true},
+		{"default probability", first.Extensions.Probability, 100},
+		{"default group weight", first.Extensions.GroupWeight, 100},
+		{"default role", first.Extensions.Role, Role(RoleSystem)},
+		{"delay until recursion", first.Extensions.DelayUntilRecursion, float64(2)},
+		{"enabled", first.Enabled, true},
+		{"disabled", second.Enabled, false},
+		{"order", second.InsertionOrder, 5},
+		{"position", second.Extensions.Position, LorebookInsertionAtDepth},
+		{"depth", second.Extensions.Depth, 2},
+		{"role", second.Extensions.Role, Role(RoleUser)},
+		{"sticky", second.Extensions.Sticky, 3},
+		{"null cooldown", second.Extensions.Cooldown, 0},
+		{"triggers", second.Extensions.Triggers[0], TriggerContinue},
+		{"ignore budget", second.Extensions.IgnoreBudget, true},
+		{"default ignore budget", first.Extensions.IgnoreBudget, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v (%T), want %v (%T)", tt.got, tt.got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSillyTavernWorldInfo(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{`{"entries":{}}`, true},
+		{`{"entries": {"0":{}}}`, true},
+		{`{"spec":"lorebook_v3","data":{"entries":[]}}`, false},
+		{`{"entries":[]}`, false},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			if got := isSillyTavernWorldInfo([]byte(tt.data)); got != tt.want {
+				t.Errorf("isSillyTavernWorldInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
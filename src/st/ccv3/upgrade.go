@@ -0,0 +1,156 @@
+package ccv3
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SpecV1 用于标识没有 spec 字段的 V1 角色卡
+const SpecV1 = "chara_card_v1"
+
+// CharacterCardV1 是 V1 角色卡的结构，所有字段都位于顶层
+type CharacterCardV1 struct {
+	Name        string `json:"name"`        // 角色名称
+	Description string `json:"description"` // 角色描述
+	Personality string `json:"personality"` // 角色性格
+	Scenario    string `json:"scenario"`    // 场景设定
+	FirstMes    string `json:"first_mes"`   // 首条消息
+	MesExample  string `json:"mes_example"` // 聊天示例
+}
+
+// CharacterCardV2 是 V2 角色卡的顶层结构
+type CharacterCardV2 struct {
+	Spec        string              `json:"spec"`         // 规范标识，必须为 "chara_card_v2"
+	SpecVersion string              `json:"spec_version"` // 规范版本，必须为 "2.0"
+	Data        CharacterCardV2Data `json:"data"`         // 包含角色卡核心数据的对象
+}
+
+// CharacterCardV2Data 包含了 V2 角色卡的核心信息
+type CharacterCardV2Data struct {
+	Name                    string                 `json:"name"`
+	Description             string                 `json:"description"`
+	Personality             string                 `json:"personality"`
+	Scenario                string                 `json:"scenario"`
+	FirstMes                string                 `json:"first_mes"`
+	MesExample              string                 `json:"mes_example"`
+	CreatorNotes            string                 `json:"creator_notes"`
+	SystemPrompt            string                 `json:"system_prompt"`
+	PostHistoryInstructions string                 `json:"post_history_instructions"`
+	AlternateGreetings      []string               `json:"alternate_greetings"`
+	CharacterBook           *Lorebook              `json:"character_book,omitempty"` // V2 设定集与 V3 结构兼容
+	Tags                    []string               `json:"tags"`
+	Creator                 string                 `json:"creator"`
+	CharacterVersion        string                 `json:"character_version"`
+	Extensions              CharacterCardExtension `json:"extensions"`
+}
+
+// Parse 解析 V1、V2 或 V3 角色卡 JSON 并统一转换为 V3 格式，同时返回原始的规范标识
+func Parse(data []byte) (CharacterCard, string, error) {
+	header := struct {
+		Spec        string `json:"spec"`
+		SpecVersion string `json:"spec_version"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return CharacterCard{}, "", fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+
+	switch header.Spec {
+	case SpecV3:
+		if header.SpecVersion != SpecVersionV3 {
+			return CharacterCard{}, header.Spec, fmt.Errorf("unsupported spec_version: %s", header.SpecVersion)
+		}
+		card := CharacterCard{}
+		if err := json.Unmarshal(data, &card); err != nil {
+			return CharacterCard{}, header.Spec, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		return card, header.Spec, nil
+	case SpecV2:
+		v2 := CharacterCardV2{}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return CharacterCard{}, header.Spec, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		return UpgradeV2(v2), header.Spec, nil
+	case "":
+		v1 := CharacterCardV1{}
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return CharacterCard{}, SpecV1, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		return UpgradeV1(v1), SpecV1, nil
+	default:
+		return CharacterCard{}, header.Spec, fmt.Errorf("unsupported spec: %s", header.Spec)
+	}
+}
+
+// UpgradeV1 将 V1 角色卡转换为 V3 角色卡
+func UpgradeV1(v1 CharacterCardV1) CharacterCard {
+	return UpgradeV2(CharacterCardV2{
+		Spec:        SpecV2,
+		SpecVersion: SpecVersionV2,
+		Data: CharacterCardV2Data{
+			Name:        v1.Name,
+			Description: v1.Description,
+			Personality: v1.Personality,
+			Scenario:    v1.Scenario,
+			FirstMes:    v1.FirstMes,
+			MesExample:  v1.MesExample,
+		},
+	})
+}
+
+// UpgradeV2 将 V2 角色卡转换为 V3 角色卡
+func UpgradeV2(v2 CharacterCardV2) CharacterCard {
+	d := v2.Data
+	data := CharacterCardData{
+		Name:                    d.Name,
+		Description:             d.Description,
+		Tags:                    d.Tags,
+		Creator:                 d.Creator,
+		CharacterVersion:        d.CharacterVersion,
+		MesExample:              d.MesExample,
+		Extensions:              d.Extensions,
+		SystemPrompt:            d.SystemPrompt,
+		PostHistoryInstructions: d.PostHistoryInstructions,
+		FirstMes:                d.FirstMes,
+		AlternateGreetings:      d.AlternateGreetings,
+		Personality:             d.Personality,
+		Scenario:                d.Scenario,
+		CreatorNotes:            d.CreatorNotes,
+		CharacterBook:           d.CharacterBook,
+		GroupOnlyGreetings:      []string{},
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	if data.AlternateGreetings == nil {
+		data.AlternateGreetings = []string{}
+	}
+	if dp := &data.Extensions.DepthPrompt; dp.Prompt != "" && dp.Role == "" {
+		dp.Role = "system"
+	}
+	if book := data.CharacterBook; book != nil {
+		if book.Extensions == nil {
+			book.Extensions = map[string]interface{}{}
+		}
+		for i := range book.Entries {
+			upgradeV2LorebookEntry(&book.Entries[i])
+		}
+	}
+	return CharacterCard{
+		Spec:        SpecV3,
+		SpecVersion: SpecVersionV3,
+		Data:        data,
+	}
+}
+
+// upgradeV2LorebookEntry 将 V2 设定集条目的顶层字段迁移到 V3 使用的扩展字段
+func upgradeV2LorebookEntry(entry *LorebookEntry) {
+	ext := &entry.Extensions
+	// V2 only knows before_char and after_char, which are the first two positions
+	if entry.Position == "after_char" && ext.Position == LorebookInsertionBeforeCharDefs {
+		ext.Position = LorebookInsertionAfterCharDefs
+	}
+	if entry.CaseSensitive && ext.CaseSensitive == nil {
+		caseSensitive := true
+		ext.CaseSensitive = &caseSensitive
+	}
+}
@@ -0,0 +1,88 @@
+package ccv3
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantSpec string
+		wantErr  bool
+		check    func(t *testing.T, card CharacterCard)
+	}{
+		{
+			"v1", `{"name":"Alice","description":"desc","first_mes":"hi","mes_example":"ex"}`, SpecV1, false,
+			func(t *testing.T, card CharacterCard) {
+				if card.Data.Name != "Alice" || card.Data.Description != "desc" || card.Data.FirstMes != "hi" || card.Data.MesExample != "ex" {
+					t.Errorf("V1 fields not upgraded: %+v", card.Data)
+				}
+				if card.Data.Tags == nil || card.Data.AlternateGreetings == nil || card.Data.GroupOnlyGreetings == nil {
+					t.Error("V3 arrays must not be nil")
+				}
+			},
+		},
+		{
+			"v2", `{"spec":"chara_card_v2","spec_version":"2.0","data":{
+				"name":"Alice","system_prompt":"sys","alternate_greetings":["hey"],
+				"extensions":{"depth_prompt":{"prompt":"dp","depth":4}},
+				"character_book":{"entries":[
+					{"keys":["a"],"content":"A","enabled":true,"position":"after_char","case_sensitive":true},
+					{"keys":["b"],"content":"B","enabled":true,"position":"before_char"}
+				]}}}`, SpecV2, false,
+			func(t *testing.T, card CharacterCard) {
+				if card.Spec != SpecV3 || card.SpecVersion != SpecVersionV3 {
+					t.Errorf("spec = %s %s, want V3", card.Spec, card.SpecVersion)
+				}
+				if card.Data.SystemPrompt != "sys" || len(card.Data.AlternateGreetings) != 1 {
+					t.Errorf("V2 fields not upgraded: %+v", card.Data)
+				}
+				if role := card.Data.Extensions.DepthPrompt.Role; role != "system" {
+					t.Errorf("depth prompt role = %q, want system", role)
+				}
+				book := card.Data.CharacterBook
+				if book == nil || len(book.Entries) != 2 {
+					t.Fatalf("character book not upgraded: %+v", book)
+				}
+				if !book.RecursiveScanning {
+					t.Error("recursive_scanning should default to true")
+				}
+				if pos := book.Entries[0].Extensions.Position; pos != LorebookInsertionAfterCharDefs {
+					t.Errorf("entries[0] position = %d, want after char defs", pos)
+				}
+				if cs := book.Entries[0].Extensions.CaseSensitive; cs == nil || !*cs {
+					t.Error("entries[0] case_sensitive not moved to extensions")
+				}
+				if pos := book.Entries[1].Extensions.Position; pos != LorebookInsertionBeforeCharDefs {
+					t.Errorf("entries[1] position = %d, want before char defs", pos)
+				}
+			},
+		},
+		{
+			"v3", `{"spec":"chara_card_v3","spec_version":"3.0","data":{"name":"Alice","nickname":"Ali"}}`, SpecV3, false,
+			func(t *testing.T, card CharacterCard) {
+				if card.Data.Nickname != "Ali" {
+					t.Errorf("nickname = %q, want Ali", card.Data.Nickname)
+				}
+			},
+		},
+		{"unsupported spec version", `{"spec":"chara_card_v3","spec_version":"4.0","data":{}}`, SpecV3, true, nil},
+		{"unsupported spec", `{"spec":"chara_card_v9","data":{}}`, "chara_card_v9", true, nil},
+		{"invalid JSON", `{`, "", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card, spec, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if spec != tt.wantSpec {
+				t.Errorf("Parse() spec = %q, want %q", spec, tt.wantSpec)
+			}
+			if tt.check != nil {
+				tt.check(t, card)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package ccv3
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Severity 定义诊断信息的严重程度
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // 角色卡无法使用
+	SeverityWarning Severity = "warning" // 角色卡可以使用，但部分内容会被忽略或行为可能不符合预期
+)
+
+// Diagnostic 描述角色卡中某个字段的问题
+type Diagnostic struct {
+	Path     string   `json:"path"`     // 字段路径 (如 data.character_book.entries[3].extensions.role)
+	Severity Severity `json:"severity"` // 严重程度
+	Message  string   `json:"message"`  // 问题描述
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Path, d.Message)
+}
+
+// Diagnostics 是一组诊断信息，可作为 error 使用
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	messages := make([]string, 0, len(d))
+	for _, diag := range d {
+		messages = append(messages, diag.Path+": "+diag.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// HasError 判断是否包含错误级别的诊断信息
+func (d Diagnostics) HasError() bool {
+	return len(d.Errors()) > 0
+}
+
+// Errors 返回错误级别的诊断信息
+func (d Diagnostics) Errors() Diagnostics {
+	return d.filter(SeverityError)
+}
+
+// Warnings 返回警告级别的诊断信息
+func (d Diagnostics) Warnings() Diagnostics {
+	return d.filter(SeverityWarning)
+}
+
+func (d Diagnostics) filter(severity Severity) Diagnostics {
+	filtered := make(Diagnostics, 0, len(d))
+	for _, diag := range d {
+		if diag.Severity == severity {
+			filtered = append(filtered, diag)
+		}
+	}
+	return filtered
+}
+
+func (d *Diagnostics) errorf(path string, format string, args ...any) {
+	*d = append(*d, Diagnostic{Path: path, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *Diagnostics) warnf(path string, format string, args ...any) {
+	*d = append(*d, Diagnostic{Path: path, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate 检查角色卡并返回字段级的诊断信息
+func Validate(card CharacterCard) Diagnostics {
+	d := make(Diagnostics, 0)
+	if card.Spec != SpecV3 {
+		d.errorf("spec", "must be %q, got %q", SpecV3, card.Spec)
+	}
+	if card.SpecVersion != SpecVersionV3 {
+		d.errorf("spec_version", "must be %q, got %q", SpecVersionV3, card.SpecVersion)
+	}
+
+	data := card.Data
+	if data.Name == "" {
+		d.errorf("data.name", "character name is required")
+	}
+	if dp := data.Extensions.DepthPrompt; dp.Prompt != "" {
+		switch dp.Role {
+		case "system", "user", "assistant":
+		default:
+			d.errorf("data.extensions.depth_prompt.role", "unknown role %q", dp.Role)
+		}
+		if dp.Depth < 0 {
+			d.errorf("data.extensions.depth_prompt.depth", "must not be negative, got %d", dp.Depth)
+		}
+	}
+	for i, asset := range data.Assets {
+		path := fmt.Sprintf("data.assets[%d]", i)
+		if asset.URI == "" {
+			d.errorf(path+".uri", "asset URI is required")
+		}
+		if asset.Type == "" {
+			d.warnf(path+".type", "asset type is empty")
+		}
+	}
+	if data.CharacterBook != nil {
+		d = append(d, ValidateLorebook(*data.CharacterBook, "data.character_book")...)
+	}
+	return d
+}
+
+// ValidateLorebook 检查设定集并返回字段级的诊断信息，path 为设定集所在的字段路径
+func ValidateLorebook(book Lorebook, path string) Diagnostics {
+	d := make(Diagnostics, 0)
+	if book.ScanDepth < 0 {
+		d.warnf(path+".scan_depth", "negative scan depth %d will be ignored", book.ScanDepth)
+	}
+	if book.TokenBudget < 0 {
+		d.warnf(path+".token_budget", "negative token budget %d will be ignored", book.TokenBudget)
+	}
+	for i, entry := range book.Entries {
+		d = append(d, validateLorebookEntry(entry, fmt.Sprintf("%s.entries[%d]", path, i))...)
+	}
+	return d
+}
+
+func validateLorebookEntry(entry LorebookEntry, path string) Diagnostics {
+	d := make(Diagnostics, 0)
+	ext := entry.Extensions
+	if ext.Role < RoleSystem || ext.Role > RoleAssistant {
+		d.warnf(path+".extensions.role", "unknown role %d, entry will be ignored", ext.Role)
+	}
+	if ext.ScanDepth < 0 {
+		d.warnf(path+".extensions.scan_depth", "negative scan depth %d will be ignored", ext.ScanDepth)
+	}
+	if ext.Depth < 0 {
+		d.warnf(path+".extensions.depth", "negative depth %d will be treated as 0", ext.Depth)
+	}
+	if ext.Probability < 0 || ext.Probability > 100 {
+		d.warnf(path+".extensions.probability", "probability %d will be clamped to 0-100", ext.Probability)
+	}
+	switch v := ext.DelayUntilRecursion.(type) {
+	case nil, bool:
+	case float64:
+		if v < 0 {
+			d.warnf(path+".extensions.delay_until_recursion", "negative level %v will be ignored", v)
+		} else if v != math.Trunc(v) {
+			d.warnf(path+".extensions.delay_until_recursion", "level %v will be truncated to %d", v, int(v))
+		}
+	default:
+		d.warnf(path+".extensions.delay_until_recursion", "must be a boolean or a number, %v will be ignored", v)
+	}
+	for i, trigger := range ext.Triggers {
+		t, ok := trigger.(string)
+		if !ok {
+			d.warnf(fmt.Sprintf("%s.extensions.triggers[%d]", path, i), "must be a string, %v will be ignored", trigger)
+		} else if !slices.Contains(Triggers(), t) {
+			d.warnf(fmt.Sprintf("%s.extensions.triggers[%d]", path, i), "unknown generation type %q will never match", t)
+		}
+	}
+	if ext.SelectiveLogic < SelectiveLogicAndAny || ext.SelectiveLogic > SelectiveLogicAndAll {
+		d.warnf(path+".extensions.selectiveLogic", "unknown selective logic %d will be treated as AND ANY", ext.SelectiveLogic)
+	}
+	if ext.Position < LorebookInsertionBeforeCharDefs || ext.Position > LorebookInsertionAfterExampleMessages {
+		d.warnf(path+".extensions.position", "unsupported position %d, entry will be ignored", ext.Position)
+	}
+	switch entry.Position {
+	case "", "before_char", "after_char":
+	default:
+		d.warnf(path+".position", "unsupported position %q will be ignored", entry.Position)
+	}
+	if !entry.Enabled {
+		return d
+	}
+	if len(entry.Keys) == 0 && !entry.Constant && !ext.Vectorized {
+		d.warnf(path+".keys", "entry has no keys and will never activate")
+	}
+	for i, key := range entry.Keys {
+		validateKey(&d, fmt.Sprintf("%s.keys[%d]", path, i), key, entry.UseRegex)
+	}
+	for i, key := range entry.SecondaryKeys {
+		validateKey(&d, fmt.Sprintf("%s.secondary_keys[%d]", path, i), key, entry.UseRegex)
+	}
+	if ext.Vectorized && entry.Content == "" {
+		d.warnf(path+".content", "vectorized entry has no content and will never activate")
+	}
+	return d
+}
+
+func validateKey(d *Diagnostics, path string, key string, useRegex bool) {
+	if IsKeyRegex(key) {
+		if _, err := ParseKeyRegex(key); err != nil {
+			d.warnf(path, "invalid regex key will be matched as plain text: %v", err)
+		}
+		return
+	}
+	if useRegex {
+		if _, err := regexp.Compile(key); err != nil {
+			d.warnf(path, "invalid regex key: %v", err)
+		}
+	}
+}
+
+var reKeyRegex = regexp.MustCompile(`^/([\w\W]+?)/([gimsuy]*)$`)
+var reUnescapedSlash = regexp.MustCompile(`(^|[^\\])/`)
+
+// IsKeyRegex 判断关键词是否为 /pattern/flags 形式的正则表达式
+func IsKeyRegex(key string) bool {
+	return reKeyRegex.MatchString(key)
+}
+
+// ParseKeyRegex 将 /pattern/flags 形式的关键词解析为正则表达式
+func ParseKeyRegex(key string) (*regexp.Regexp, error) {
+	matches := reKeyRegex.FindStringSubmatch(key)
+	if len(matches) != 3 {
+		return nil, fmt.Errorf("invalid regex pattern: %s", key)
+	}
+	re := matches[1]
+	flags := matches[2]
+	if flags != "" {
+		flagStr := ""
+		if strings.Contains(flags, "i") {
+			flagStr = flagStr + "i"
+		}
+		if strings.Contains(flags, "m") {
+			flagStr = flagStr + "m"
+		}
+		if strings.Contains(flags, "s") {
+			flagStr = flagStr + "s"
+		}
+		if flagStr != "" {
+			re = "(?" + flagStr + ")" + re
+		}
+	}
+	if reUnescapedSlash.MatchString(re) {
+		return nil, fmt.Errorf("unescaped slash in regex pattern: %s", key)
+	}
+	re = strings.ReplaceAll(re, "\\/", "/")
+	return regexp.Compile(re)
+}
@@ -0,0 +1,102 @@
+package ccv3
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	withEntry := func(modify func(e *LorebookEntry)) func(c *CharacterCard) {
+		return func(c *CharacterCard) {
+			entry := LorebookEntry{Keys: []string{"a"}, Content: "A", Enabled: true}
+			modify(&entry)
+			c.Data.CharacterBook = &Lorebook{Entries: []LorebookEntry{entry}}
+		}
+	}
+	const entry = "data.character_book.entries[0]"
+	tests := []struct {
+		name     string
+		modify   func(c *CharacterCard)
+		wantPath string
+		want     Severity
+	}{
+		{"valid", func(c *CharacterCard) {}, "", ""},
+		{"spec", func(c *CharacterCard) { c.Spec = SpecV2 }, "spec", SeverityError},
+		{"spec version", func(c *CharacterCard) { c.SpecVersion = "2.0" }, "spec_version", SeverityError},
+		{"name", func(c *CharacterCard) { c.Data.Name = "" }, "data.name", SeverityError},
+		{"depth prompt role", func(c *CharacterCard) {
+			c.Data.Extensions.DepthPrompt = CharacterCardDepthPrompt{Prompt: "p", Role: "narrator"}
+		}, "data.extensions.depth_prompt.role", SeverityError},
+		{"depth prompt depth", func(c *CharacterCard) {
+			c.Data.Extensions.DepthPrompt = CharacterCardDepthPrompt{Prompt: "p", Role: "system", Depth: -1}
+		}, "data.extensions.depth_prompt.depth", SeverityError},
+		{"asset uri", func(c *CharacterCard) { c.Data.Assets = []Asset{{Type: "icon"}} }, "data.assets[0].uri", SeverityError},
+		{"asset type", func(c *CharacterCard) { c.Data.Assets = []Asset{{URI: "ccdefault:"}} }, "data.assets[0].type", SeverityWarning},
+		{"book scan depth", func(c *CharacterCard) { c.Data.CharacterBook = &Lorebook{ScanDepth: -1} }, "data.character_book.scan_depth", SeverityWarning},
+		{"entry role", withEntry(func(e *LorebookEntry) { e.Extensions.Role = 5 }), entry + ".extensions.role", SeverityWarning},
+		{"entry position", withEntry(func(e *LorebookEntry) { e.Extensions.Position = 7 }), entry + ".extensions.position", SeverityWarning},
+		{"entry probability", withEntry(func(e *LorebookEntry) { e.Extensions.Probability = 150 }), entry + ".extensions.probability", SeverityWarning},
+		{"entry depth", withEntry(func(e *LorebookEntry) { e.Extensions.Depth = -1 }), entry + ".extensions.depth", SeverityWarning},
+		{"selective logic", withEntry(func(e *LorebookEntry) { e.Extensions.SelectiveLogic = 4 }), entry + ".extensions.selectiveLogic", SeverityWarning},
+		{"delay level", withEntry(func(e *LorebookEntry) { e.Extensions.DelayUntilRecursion = 1.5 }), entry + ".extensions.delay_until_recursion", SeverityWarning},
+		{"delay type", withEntry(func(e *LorebookEntry) { e.Extensions.DelayUntilRecursion = "1" }), entry + ".extensions.delay_until_recursion", SeverityWarning},
+		{"valid delay level", withEntry(func(e *LorebookEntry) { e.Extensions.DelayUntilRecursion = float64(2) }), "", ""},
+		{"trigger type", withEntry(func(e *LorebookEntry) { e.Extensions.Triggers = []any{1} }), entry + ".extensions.triggers[0]", SeverityWarning},
+		{"unknown trigger", withEntry(func(e *LorebookEntry) { e.Extensions.Triggers = []any{"later"} }), entry + ".extensions.triggers[0]", SeverityWarning},
+		{"v2 position", withEntry(func(e *LorebookEntry) { e.Position = "top" }), entry + ".position", SeverityWarning},
+		{"no keys", withEntry(func(e *LorebookEntry) { e.Keys = nil }), entry + ".keys", SeverityWarning},
+		{"no keys but constant", withEntry(func(e *LorebookEntry) { e.Keys, e.Constant = nil, true }), "", ""},
+		{"disabled without keys", withEntry(func(e *LorebookEntry) { e.Keys, e.Enabled = nil, false }), "", ""},
+		{"invalid regex key", withEntry(func(e *LorebookEntry) { e.Keys = []string{"/a(/"} }), entry + ".keys[0]", SeverityWarning},
+		{"invalid use_regex key", withEntry(func(e *LorebookEntry) { e.Keys, e.UseRegex = []string{"a("}, true }), entry + ".keys[0]", SeverityWarning},
+		{"invalid secondary key", withEntry(func(e *LorebookEntry) { e.SecondaryKeys = []string{"/a(/"} }), entry + ".secondary_keys[0]", SeverityWarning},
+		{"vectorized without content", withEntry(func(e *LorebookEntry) { e.Content, e.Extensions.Vectorized = "", true }), entry + ".content", SeverityWarning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := CharacterCard{Spec: SpecV3, SpecVersion: SpecVersionV3, Data: CharacterCardData{Name: "Alice"}}
+			tt.modify(&card)
+			d := Validate(card)
+			if tt.wantPath == "" {
+				if len(d) != 0 {
+					t.Errorf("Validate() = %v, want no diagnostics", d)
+				}
+				return
+			}
+			if len(d) != 1 || d[0].Path != tt.wantPath || d[0].Severity != tt.want {
+				t.Errorf("Validate() = %v, want one %s at %s", d, tt.want, tt.wantPath)
+			}
+			if d.HasError() != (tt.want == SeverityError) {
+				t.Errorf("HasError() = %v", d.HasError())
+			}
+		})
+	}
+}
+
+func TestParseKeyRegex(t *testing.T) {
+	tests := []struct {
+		key     string
+		text    string
+		want    bool
+		wantErr bool
+	}{
+		{"/dra+gon/", "draaagon", true, false},
+		{"/dragon/", "DRAGON", false, false},
+		{"/dragon/i", "DRAGON", true, false},
+		{"/^b/m", "a\nb", true, false},
+		{"/a.b/s", "a\nb", true, false},
+		{"/a\\/b/", "a/b", true, false},
+		{"dragon", "", false, true},
+		{"/a(/", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			re, err := ParseKeyRegex(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && re.MatchString(tt.text) != tt.want {
+				t.Errorf("%s matches %q = %v, want %v", tt.key, tt.text, !tt.want, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package st
+
+import (
+	"github.com/cloudwindy/xitu/st/tokenizer"
+	"github.com/rs/zerolog/log"
+)
+
+// messageTokenOverhead 是每条消息除内容外额外占用的 token 数 (角色、分隔符等)
+const messageTokenOverhead = 3
+
+// TokenCounter 计算文本的 token 数，tokenizer 包中的分词器均实现了此接口
+type TokenCounter interface {
+	Count(text string) int
+}
+
+// ContextBudget 定义上游模型的上下文窗口预算
+type ContextBudget struct {
+	ContextSize     int          // 上下文窗口大小 (token)，0 为不限制
+	ResponseReserve int          // 为回复预留的 token 数
+	TrimExamples    bool         // 超出预算时先丢弃示例对话，再丢弃聊天记录
+	Counter         TokenCounter // token 计数器 (可选, 默认按字符数估算)
+
+	WorldInfoBudget    int // World Info 的 token 预算，为上下文窗口的百分比，0 为不限制
+	WorldInfoBudgetCap int // World Info 的 token 预算上限，0 为不限制
+}
+
+// ApplyReport 记录单次应用角色卡时的组装结果
+type ApplyReport struct {
+	PromptTokens    int  `json:"prompt_tokens"`    // 提示词的 token 数
+	DroppedMessages int  `json:"dropped_messages"` // 因超出上下文预算而丢弃的最早的聊天记录条数
+	DroppedExamples bool `json:"dropped_examples"` // 是否因超出上下文预算而丢弃了示例对话
+
+	WorldInfoTokens int      `json:"world_info_tokens"` // 激活的 World Info 条目的 token 数
+	SkippedEntries  []string `json:"skipped_entries"`   // 因超出 token 预算而未激活的条目
+}
+
+func (b *ContextBudget) counter() TokenCounter {
+	if b.Counter == nil {
+		return tokenizer.NewEstimator()
+	}
+	return b.Counter
+}
+
+func countMessages(counter TokenCounter, messages []messageType) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += countMessage(counter, msg)
+	}
+	return tokens
+}
+
+func countMessage(counter TokenCounter, msg messageType) int {
+	tokens := counter.Count(msg.Content) + messageTokenOverhead
+	if msg.Name != "" {
+		tokens += counter.Count(msg.Name)
+	}
+	return tokens
+}
+
+// fitContext 按上下文预算丢弃示例对话与最早的聊天记录，返回保留的聊天记录。
+// 角色定义等其他提示词块与按深度插入的内容始终保留，最后一条聊天记录不会被丢弃。
+func (c *cardType) fitContext(history []messageType, blocks [][]messageType, wi *worldInfoType, injections map[int][]messageType, budget *ContextBudget, report *ApplyReport) []messageType {
+	counter := budget.counter()
+	available := budget.ContextSize - budget.ResponseReserve
+
+	total := 0
+	examples := -1
+	for i, block := range blocks {
+		if budget.TrimExamples && c.PromptOrder[i].Identifier == PromptExamples && len(block) > 0 {
+			examples = i
+		}
+		total += countMessages(counter, block)
+	}
+	historyTokens := make([]int, len(history))
+	for i, msg := range history {
+		historyTokens[i] = countMessage(counter, msg)
+	}
+	total += countMessages(counter, c.buildMainChat(history, wi, injections))
+
+	if total > available && examples >= 0 {
+		total -= countMessages(counter, blocks[examples])
+		blocks[examples] = nil
+		report.DroppedExamples = true
+	}
+	dropped := 0
+	for total > available && dropped < len(history)-1 {
+		total -= historyTokens[dropped]
+		dropped++
+	}
+	report.PromptTokens = total
+	report.DroppedMessages = dropped
+
+	ev := log.Debug()
+	if total > available {
+		ev = log.Warn()
+	}
+	ev.Int("tokens", total).
+		Int("available", available).
+		Int("dropped", dropped).
+		Bool("dropped_examples", report.DroppedExamples).
+		Msg("Context fitted")
+	return history[dropped:]
+}
@@ -0,0 +1,169 @@
+package st
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/sashabaranov/go-openai"
+)
+
+// wordCounter 按空白分隔的单词数计算 token 数
+type wordCounter struct{}
+
+func (wordCounter) Count(text string) int {
+	return len(strings.Fields(text))
+}
+
+// testOpenAIChat 返回 n 条单词消息，用户与助手交替并以用户消息结尾
+func testOpenAIChat(n int) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, n)
+	for i := range n {
+		role := openai.ChatMessageRoleUser
+		if (n-1-i)%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{Role: role, Content: "word"})
+	}
+	return messages
+}
+
+func TestFitContext(t *testing.T) {
+	examples := "<START>\n{{user}}: one two three\n{{char}}: four five six"
+	// Without examples, the prompt is "[Start a new Chat]" (4 + 3 tokens) and 5 messages of 1 + 3 tokens
+	const full = 7 + 5*4
+	tests := []struct {
+		name         string
+		examples     string
+		budget       ContextBudget
+		wantDropped  int
+		wantExamples bool
+	}{
+		{"fits", "", ContextBudget{ContextSize: full}, 0, false},
+		{"drops oldest", "", ContextBudget{ContextSize: full - 1}, 1, false},
+		{"drops several", "", ContextBudget{ContextSize: full - 8}, 2, false},
+		{"response reserve", "", ContextBudget{ContextSize: full + 4, ResponseReserve: 5}, 1, false},
+		{"keeps last message", "", ContextBudget{ContextSize: 1}, 4, false},
+		{"drops history before examples", examples, ContextBudget{ContextSize: full}, 4, false},
+		{"trims examples first", examples, ContextBudget{ContextSize: full, TrimExamples: true}, 0, true},
+		{"trims examples and history", examples, ContextBudget{ContextSize: full - 1, TrimExamples: true}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{MesExample: tt.examples})
+			budget := tt.budget
+			budget.Counter = wordCounter{}
+			report := ApplyReport{}
+			messages, err := c.Apply(testOpenAIChat(5), ApplyOptions{Budget: &budget, Report: &report})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.DroppedMessages != tt.wantDropped {
+				t.Errorf("DroppedMessages = %d, want %d", report.DroppedMessages, tt.wantDropped)
+			}
+			if report.DroppedExamples != tt.wantExamples {
+				t.Errorf("DroppedExamples = %v, want %v", report.DroppedExamples, tt.wantExamples)
+			}
+			tokens := 0
+			for _, msg := range messages {
+				m, _ := parseOpenAIMessage(msg)
+				tokens += countMessage(wordCounter{}, m)
+			}
+			if tokens != report.PromptTokens {
+				t.Errorf("PromptTokens = %d, counted %d", report.PromptTokens, tokens)
+			}
+		})
+	}
+}
+
+func TestWorldInfoBudget(t *testing.T) {
+	entry := func(name string, order int, words int) ccv3.LorebookEntry {
+		e := testEntry(name, "dragon")
+		e.InsertionOrder = order
+		e.Content = strings.TrimSpace(strings.Repeat("word ", words))
+		return e
+	}
+	constant := func(e ccv3.LorebookEntry) ccv3.LorebookEntry {
+		e.Constant = true
+		return e
+	}
+	ignoreBudget := func(e ccv3.LorebookEntry) ccv3.LorebookEntry {
+		e.Extensions.IgnoreBudget = true
+		return e
+	}
+	tests := []struct {
+		name        string
+		entries     []ccv3.LorebookEntry
+		budget      ContextBudget
+		bookBudget  int
+		wantActive  []string
+		wantSkipped []string
+	}{
+		{
+			"unlimited",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), entry("b", 20, 2), entry("c", 10, 2)},
+			ContextBudget{}, 0,
+			[]string{"a", "b", "c"}, nil,
+		},
+		{
+			"cap by order",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), entry("b", 20, 2), entry("c", 10, 2)},
+			ContextBudget{WorldInfoBudgetCap: 5}, 0,
+			[]string{"a", "b"}, []string{"c"},
+		},
+		{
+			"percentage of context",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), entry("b", 20, 2), entry("c", 10, 2)},
+			ContextBudget{ContextSize: 100, WorldInfoBudget: 2}, 0,
+			[]string{"a"}, []string{"b", "c"},
+		},
+		{
+			"constant first",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), entry("b", 20, 2), constant(entry("c", 10, 2))},
+			ContextBudget{WorldInfoBudgetCap: 4}, 0,
+			[]string{"a", "c"}, []string{"b"},
+		},
+		{
+			"exhausted on first overflow",
+			[]ccv3.LorebookEntry{entry("a", 30, 5), entry("b", 20, 2)},
+			ContextBudget{WorldInfoBudgetCap: 4}, 0,
+			[]string{}, []string{"a", "b"},
+		},
+		{
+			"lorebook token budget",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), entry("b", 20, 2)},
+			ContextBudget{}, 3,
+			[]string{"a"}, []string{"b"},
+		},
+		{
+			"ignore budget",
+			[]ccv3.LorebookEntry{entry("a", 30, 5), ignoreBudget(entry("b", 20, 2)), entry("c", 10, 1)},
+			ContextBudget{WorldInfoBudgetCap: 4}, 0,
+			[]string{"b"}, []string{"a", "c"},
+		},
+		{
+			"ignore lorebook budget",
+			[]ccv3.LorebookEntry{entry("a", 30, 2), ignoreBudget(entry("b", 20, 2))},
+			ContextBudget{}, 3,
+			[]string{"a", "b"}, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{}, tt.entries...)
+			c.lorebook[0].book.data.TokenBudget = tt.bookBudget
+			budget := tt.budget
+			budget.Counter = wordCounter{}
+			report := ApplyReport{}
+			got := activatedNames(t, c, testChat("a dragon"), ApplyOptions{Budget: &budget, Report: &report})
+			if !slices.Equal(got, tt.wantActive) {
+				t.Errorf("activated = %v, want %v", got, tt.wantActive)
+			}
+			slices.Sort(report.SkippedEntries)
+			if !slices.Equal(report.SkippedEntries, tt.wantSkipped) {
+				t.Errorf("SkippedEntries = %v, want %v", report.SkippedEntries, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+// Package embedding 提供文本向量化与内存向量索引，用于向量化设定集条目的相似度激活
+package embedding
+
+import "context"
+
+// Embedder 将文本转换为向量
+type Embedder interface {
+	// Name 返回向量化模型名称
+	Name() string
+	// Embed 返回每段文本的向量
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
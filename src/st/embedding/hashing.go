@@ -0,0 +1,104 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// HashingName 是本地哈希向量化的名称
+const HashingName = "hashing"
+
+// Hashing 使用特征哈希将文本的词袋转换为向量，无需模型文件。
+// 拉丁字母与数字按单词切分，中日韩文字按单字与相邻双字切分。
+type Hashing struct {
+	Dimensions int
+}
+
+// NewHashing 返回指定维度的哈希向量化，dimensions 不大于 0 时使用 1024
+func NewHashing(dimensions int) *Hashing {
+	if dimensions <= 0 {
+		dimensions = 1024
+	}
+	return &Hashing{Dimensions: dimensions}
+}
+
+func (h *Hashing) Name() string {
+	return HashingName
+}
+
+func (h *Hashing) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vectors = append(vectors, h.vector(text))
+	}
+	return vectors, nil
+}
+
+func (h *Hashing) vector(text string) []float32 {
+	v := make([]float32, h.Dimensions)
+	for _, term := range terms(text) {
+		f := fnv.New32a()
+		_, _ = f.Write([]byte(term))
+		sum := f.Sum32()
+		// Use the highest bit as the sign to reduce the bias of collisions
+		sign := float32(1)
+		if sum&(1<<31) != 0 {
+			sign = -1
+		}
+		v[int(sum&(1<<31-1))%h.Dimensions] += sign
+	}
+	normalize(v)
+	return v
+}
+
+// terms 将文本切分为词项
+func terms(text string) []string {
+	text = strings.ToLower(text)
+	terms := make([]string, 0)
+	word := strings.Builder{}
+	var prev rune
+	flush := func() {
+		if word.Len() > 0 {
+			terms = append(terms, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			terms = append(terms, string(r))
+			if isCJK(prev) {
+				terms = append(terms, string([]rune{prev, r}))
+			}
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+		prev = r
+	}
+	flush()
+	return terms
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func normalize(v []float32) {
+	norm := float32(0)
+	for _, x := range v {
+		norm += x * x
+	}
+	if norm == 0 {
+		return
+	}
+	norm = float32(math.Sqrt(float64(norm)))
+	for i := range v {
+		v[i] /= norm
+	}
+}
@@ -0,0 +1,59 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"abc123 def", []string{"abc123", "def"}},
+		{"魔法学院", []string{"魔", "法", "魔法", "学", "法学", "院", "学院"}},
+		{"去Tokyo旅行", []string{"去", "tokyo", "旅", "行", "旅行"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := terms(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("terms(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashingEmbed(t *testing.T) {
+	h := NewHashing(256)
+	vectors, err := h.Embed(context.Background(), []string{
+		"the dragon sleeps in the mountain",
+		"a dragon in the mountain",
+		"quarterly revenue report",
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range vectors[:3] {
+		if len(v) != 256 {
+			t.Fatalf("len(vectors[%d]) = %d, want 256", i, len(v))
+		}
+		norm := float64(0)
+		for _, x := range v {
+			norm += float64(x * x)
+		}
+		if math.Abs(norm-1) > 1e-5 {
+			t.Errorf("vectors[%d] is not normalized: %v", i, norm)
+		}
+	}
+	if similar, unrelated := cosine(vectors[0], vectors[1]), cosine(vectors[0], vectors[2]); similar <= unrelated {
+		t.Errorf("similar texts scored %v, unrelated %v", similar, unrelated)
+	}
+	if score := cosine(vectors[0], vectors[3]); score != 0 {
+		t.Errorf("empty text scored %v, want 0", score)
+	}
+}
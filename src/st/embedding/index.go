@@ -0,0 +1,111 @@
+package embedding
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Match 是一条检索结果
+type Match struct {
+	ID    string
+	Score float32 // 余弦相似度
+}
+
+// Index 是内存中的向量索引，超出容量时淘汰最久未使用的向量
+type Index struct {
+	mu       sync.Mutex
+	capacity int
+	vectors  map[string]*list.Element
+	recent   *list.List // 按最近使用排序，队首为最近使用
+}
+
+type indexItem struct {
+	id     string
+	vector []float32
+}
+
+// NewIndex 返回一个空的向量索引，capacity 为最多保存的向量数，0 为不限制
+func NewIndex(capacity int) *Index {
+	return &Index{
+		capacity: capacity,
+		vectors:  make(map[string]*list.Element),
+		recent:   list.New(),
+	}
+}
+
+// Len 返回索引中的向量数
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.vectors)
+}
+
+// Get 返回 ID 对应的向量
+func (x *Index) Get(id string) ([]float32, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.vectors[id]
+	if !ok {
+		return nil, false
+	}
+	x.recent.MoveToFront(e)
+	return e.Value.(*indexItem).vector, true
+}
+
+// Add 添加或替换 ID 对应的向量
+func (x *Index) Add(id string, vector []float32) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.vectors[id]; ok {
+		e.Value.(*indexItem).vector = vector
+		x.recent.MoveToFront(e)
+		return
+	}
+	x.vectors[id] = x.recent.PushFront(&indexItem{id: id, vector: vector})
+	for x.capacity > 0 && len(x.vectors) > x.capacity {
+		oldest := x.recent.Back()
+		x.recent.Remove(oldest)
+		delete(x.vectors, oldest.Value.(*indexItem).id)
+	}
+}
+
+// Search 在给定的 ID 中检索相似度不低于 threshold 的向量，按相似度降序返回最多 limit 条，limit 为 0 时不限制
+func (x *Index) Search(query []float32, ids []string, threshold float32, limit int) []Match {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	matches := make([]Match, 0)
+	for _, id := range ids {
+		e, ok := x.vectors[id]
+		if !ok {
+			continue
+		}
+		if score := cosine(query, e.Value.(*indexItem).vector); score >= threshold {
+			matches = append(matches, Match{ID: id, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+func cosine(a []float32, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(na*nb))
+}
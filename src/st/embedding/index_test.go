@@ -0,0 +1,85 @@
+package embedding
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestIndexSearch(t *testing.T) {
+	x := NewIndex(0)
+	x.Add("a", []float32{1, 0})
+	x.Add("b", []float32{1, 1})
+	x.Add("c", []float32{0, 1})
+	x.Add("d", []float32{-1, 0})
+	tests := []struct {
+		name      string
+		ids       []string
+		threshold float32
+		limit     int
+		want      []string
+	}{
+		{"all above threshold", []string{"a", "b", "c", "d"}, 0.5, 0, []string{"a", "b"}},
+		{"zero threshold", []string{"a", "b", "c", "d"}, 0, 0, []string{"a", "b", "c"}},
+		{"limit", []string{"a", "b", "c", "d"}, 0, 1, []string{"a"}},
+		{"restricted ids", []string{"b", "c"}, 0, 0, []string{"b", "c"}},
+		{"unknown ids", []string{"x"}, 0, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, match := range x.Search([]float32{1, 0}, tt.ids, tt.threshold, tt.limit) {
+				got = append(got, match.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexEviction(t *testing.T) {
+	x := NewIndex(2)
+	x.Add("a", []float32{1})
+	x.Add("b", []float32{1})
+	// Using a keeps it over b
+	x.Get("a")
+	x.Add("c", []float32{1})
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, tt := range tests {
+		if _, ok := x.Get(tt.id); ok != tt.want {
+			t.Errorf("Get(%q) ok = %v, want %v", tt.id, ok, tt.want)
+		}
+	}
+	if n := x.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"same", []float32{1, 2}, []float32{2, 4}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"zero vector", []float32{0, 0}, []float32{1, 0}, 0},
+		{"length mismatch", []float32{1}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosine(tt.a, tt.b); math.Abs(float64(got-tt.want)) > 1e-6 {
+				t.Errorf("cosine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAI 使用 OpenAI 兼容的 /v1/embeddings 接口向量化文本
+type OpenAI struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAI 返回使用指定接口地址与模型的向量化客户端
+func NewOpenAI(baseURL string, apiKey string, model string) *OpenAI {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	return &OpenAI{
+		client: openai.NewClientWithConfig(config),
+		model:  model,
+	}
+}
+
+func (o *OpenAI) Name() string {
+	return o.model
+}
+
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	res, err := o.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(o.model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	if len(res.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(res.Data))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, data := range res.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index out of range: %d", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	return vectors, nil
+}
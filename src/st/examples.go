@@ -0,0 +1,93 @@
+package st
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 示例对话以 system 消息发送时使用的 name
+const (
+	exampleUserName      = "example_user"
+	exampleAssistantName = "example_assistant"
+)
+
+var reExampleStart = regexp.MustCompile(`(?i)<START>`)
+
+// buildExampleMessages 将示例对话解析为消息数组，每段示例对话以 NewExampleChat 开头
+func (c *cardType) buildExampleMessages(mesExample string) []messageType {
+	if mesExample == "" {
+		return nil
+	}
+	messages := make([]messageType, 0)
+	entries := 0
+	turns := 0
+	for _, example := range reExampleStart.Split(mesExample, -1) {
+		dialogue := c.parseExampleDialogue(example)
+		if len(dialogue) == 0 {
+			continue
+		}
+		c.pushPrompt(&messages, system, c.NewExampleChat)
+		entries++
+		for _, turn := range dialogue {
+			if c.SystemExampleMessages {
+				turn.Name = exampleUserName
+				if turn.Role == assistant {
+					turn.Name = exampleAssistantName
+				}
+				turn.Role = system
+			}
+			messages = append(messages, turn)
+			turns++
+		}
+	}
+	log.Debug().Int("entries", entries).Int("turns", turns).Msg("ExampleMessages built")
+	return messages
+}
+
+// parseExampleDialogue 将一段示例对话解析为 user/assistant 轮次。
+// 以 "{{user}}:"、"<USER>:" 或用户名开头的行开始用户轮次，以 "{{char}}:"、"<BOT>:"、角色名或昵称开头的行开始角色轮次，
+// 其余行属于当前轮次，第一个说话人之前的内容视为角色轮次。
+func (c *cardType) parseExampleDialogue(example string) []messageType {
+	example = c.evalMacros(example)
+	userPrefixes := []string{c.UserName + ":"}
+	charPrefixes := []string{c.charName() + ":", c.data.Name + ":"}
+
+	turns := make([]messageType, 0)
+	current := messageType{Role: assistant}
+	lines := make([]string, 0)
+	flush := func() {
+		content := strings.Trim(strings.Join(lines, "\n"), " \r\n")
+		if content != "" {
+			current.Content = content
+			turns = append(turns, current)
+		}
+		lines = lines[:0]
+	}
+	for _, line := range strings.Split(example, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if rest, ok := cutPrefixFold(trimmed, userPrefixes); ok {
+			flush()
+			current = messageType{Role: user}
+			line = rest
+		} else if rest, ok := cutPrefixFold(trimmed, charPrefixes); ok {
+			flush()
+			current = messageType{Role: assistant}
+			line = rest
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return turns
+}
+
+// cutPrefixFold 忽略大小写地移除 s 的第一个匹配前缀
+func cutPrefixFold(s string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			return s[len(prefix):], true
+		}
+	}
+	return s, false
+}
@@ -0,0 +1,106 @@
+package st
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestParseExampleDialogueNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickname string
+		example  string
+		want     []string
+	}{
+		{
+			"full name and nickname", "Alice",
+			"Bob: Hi.\nAlice: Hello.\nAlice Liddell: Who is asking?\n{{charFullName}}: Me again.",
+			[]string{"user:Hi.", "assistant:Hello.", "assistant:Who is asking?", "assistant:Me again."},
+		},
+		{
+			"char macro is the nickname", "Alice",
+			"{{user}}: Hi.\n{{char}}: Hello.",
+			[]string{"user:Hi.", "assistant:Hello."},
+		},
+		{
+			"nickname is a prefix of other words", "Al",
+			"Bob: Hi.\nAlbert: not a speaker\nAl: Hello.",
+			[]string{"user:Hi.\nAlbert: not a speaker", "assistant:Hello."},
+		},
+		{
+			"no nickname", "",
+			"Bob: Hi.\nAlice Liddell: Hello.\nAlice: not a speaker",
+			[]string{"user:Hi.", "assistant:Hello.\nAlice: not a speaker"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{Name: "Alice Liddell", Nickname: tt.nickname})
+			c.UserName = "Bob"
+			if got := formatMessages(c.parseExampleDialogue(tt.example)); !slices.Equal(got, tt.want) {
+				t.Errorf("parseExampleDialogue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildExampleMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		system  bool
+		example string
+		want    []string
+	}{
+		{"empty", false, "", []string{}},
+		{"only separators", false, "<START>\n<START>\n", []string{}},
+		{
+			"multiple examples", false,
+			"<START>\n{{user}}: Hi.\n{{char}}: Hello.\n<start>\n<USER>: Bye.\n<BOT>: See you.",
+			[]string{"system:[Example Chat]", "user:Hi.", "assistant:Hello.", "system:[Example Chat]", "user:Bye.", "assistant:See you."},
+		},
+		{
+			"multi-line turns and leading narration", false,
+			"<START>\n*Alice waves.*\n{{user}}: Hi.\nHow are you?\n{{char}}: Fine.",
+			[]string{"system:[Example Chat]", "assistant:*Alice waves.*", "user:Hi.\nHow are you?", "assistant:Fine."},
+		},
+		{
+			"system example messages", true,
+			"<START>\n{{user}}: Hi.\n{{char}}: Hello.",
+			[]string{"system:[Example Chat]", "system:example_user:Hi.", "system:example_assistant:Hello."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{})
+			c.SystemExampleMessages = tt.system
+			got := make([]string, 0)
+			for _, msg := range c.buildExampleMessages(tt.example) {
+				if msg.Name != "" {
+					msg.Content = msg.Name + ":" + msg.Content
+				}
+				got = append(got, formatMessages([]messageType{msg})...)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("buildExampleMessages() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageNames(t *testing.T) {
+	c := newTestCard(t, ccv3.CharacterCardData{FirstMes: "Hello."})
+	messages, err := c.Apply([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleAssistant, Content: "Hello."},
+		{Role: openai.ChatMessageRoleUser, Content: "Hi.", Name: "Bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := messages[len(messages)-1]
+	if last.Role != openai.ChatMessageRoleUser || last.Content != "Hi." || last.Name != "Bob" {
+		t.Errorf("last message = %+v, want the user message named Bob", last)
+	}
+}
@@ -0,0 +1,139 @@
+package st
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultGroupWeight 是未设置 GroupWeight 时的权重
+const defaultGroupWeight = 100
+
+// groups 返回条目所属的包含组，多个组以逗号分隔
+func (e *lorebookEntryType) groups() []string {
+	groups := make([]string, 0)
+	for _, group := range strings.Split(e.Group, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (e *lorebookEntryType) groupWeight() int {
+	if e.GroupWeight <= 0 {
+		return defaultGroupWeight
+	}
+	return e.GroupWeight
+}
+
+// filterInclusionGroups 在同一包含组中只保留一个新激活的条目，已有条目激活的组不再激活新条目。
+// 组内依次按 sticky、组评分 (关键词命中数)、GroupOverride (按 Order 优先)、GroupWeight 加权随机选择。
+func filterInclusionGroups(entries lorebookEntriesType, activated lorebookEntriesType) lorebookEntriesType {
+	won := make(map[string]struct{})
+	for _, entry := range activated {
+		for _, group := range entry.groups() {
+			won[group] = struct{}{}
+		}
+	}
+	names := make([]string, 0)
+	members := make(map[string][]int)
+	for i, entry := range entries {
+		for _, group := range entry.groups() {
+			if _, ok := members[group]; !ok {
+				names = append(names, group)
+			}
+			members[group] = append(members[group], i)
+		}
+	}
+	if len(names) == 0 {
+		return entries
+	}
+
+	removed := make([]bool, len(entries))
+	for _, group := range names {
+		candidates := make([]int, 0, len(members[group]))
+		for _, i := range members[group] {
+			if !removed[i] {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		winner := -1
+		if _, ok := won[group]; !ok {
+			winner = pickGroupWinner(entries, candidates)
+			won[group] = struct{}{}
+		}
+		for _, i := range candidates {
+			if i != winner {
+				removed[i] = true
+				log.Debug().Str("name", entries[i].Name).Str("group", group).Msg("Lorebook entry removed by inclusion group")
+			}
+		}
+	}
+
+	filtered := make(lorebookEntriesType, 0, len(entries))
+	for i, entry := range entries {
+		if !removed[i] {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// pickGroupWinner 从组内的候选条目中选择一个，entries 已按 Order 降序排列
+func pickGroupWinner(entries lorebookEntriesType, candidates []int) int {
+	filter := func(keep func(e *lorebookEntryType) bool) {
+		kept := make([]int, 0, len(candidates))
+		for _, i := range candidates {
+			if keep(&entries[i]) {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) > 0 {
+			candidates = kept
+		}
+	}
+
+	// Sticky entries keep winning until they expire
+	filter(func(e *lorebookEntryType) bool { return e.sticky })
+
+	// Keep the entries with the most key hits if group scoring is used
+	maxScore := 0
+	scoring := false
+	for _, i := range candidates {
+		if entries[i].UseGroupScoring {
+			scoring = true
+			maxScore = max(maxScore, entries[i].score)
+		}
+	}
+	if scoring {
+		filter(func(e *lorebookEntryType) bool { return !e.UseGroupScoring || e.score >= maxScore })
+	}
+
+	// Override entries win by Order
+	for _, i := range candidates {
+		if entries[i].GroupOverride {
+			return i
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	total := 0
+	for _, i := range candidates {
+		total += entries[i].groupWeight()
+	}
+	n := rand.Intn(total)
+	for _, i := range candidates {
+		n -= entries[i].groupWeight()
+		if n < 0 {
+			return i
+		}
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,103 @@
+package st
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+func TestInclusionGroups(t *testing.T) {
+	grouped := func(name string, group string, order int, keys ...string) ccv3.LorebookEntry {
+		entry := testEntry(name, keys...)
+		entry.Extensions.Group = group
+		entry.InsertionOrder = order
+		return entry
+	}
+	override := func(entry ccv3.LorebookEntry) ccv3.LorebookEntry {
+		entry.Extensions.GroupOverride = true
+		return entry
+	}
+	scoring := func(entry ccv3.LorebookEntry) ccv3.LorebookEntry {
+		entry.Extensions.UseGroupScoring = true
+		return entry
+	}
+	recursive := grouped("castle", "place", 10, "castle")
+	recursive.Content = "The castle has a dungeon."
+
+	tests := []struct {
+		name    string
+		entries []ccv3.LorebookEntry
+		text    string
+		want    []string
+	}{
+		{
+			"override wins",
+			[]ccv3.LorebookEntry{grouped("a", "g", 20, "dragon"), override(grouped("b", "g", 10, "dragon"))},
+			"a dragon", []string{"b"},
+		},
+		{
+			"highest order override wins",
+			[]ccv3.LorebookEntry{override(grouped("a", "g", 10, "dragon")), override(grouped("b", "g", 20, "dragon"))},
+			"a dragon", []string{"b"},
+		},
+		{
+			"group scoring keeps most key hits",
+			[]ccv3.LorebookEntry{
+				scoring(override(grouped("a", "g", 20, "dragon"))),
+				scoring(grouped("b", "g", 10, "dragon", "fire")),
+			},
+			"a dragon of fire", []string{"b"},
+		},
+		{
+			"ungrouped entries are kept",
+			[]ccv3.LorebookEntry{override(grouped("a", "g", 10, "dragon")), grouped("b", "g", 20, "dragon"), testEntry("c", "dragon")},
+			"a dragon", []string{"a", "c"},
+		},
+		{
+			"entry in several groups",
+			[]ccv3.LorebookEntry{
+				override(grouped("a", "g1, g2", 30, "dragon")),
+				grouped("b", "g1", 20, "dragon"),
+				grouped("c", "g2", 10, "dragon"),
+				grouped("d", "g3", 10, "dragon"),
+			},
+			"a dragon", []string{"a", "d"},
+		},
+		{
+			"group already won in an earlier step",
+			[]ccv3.LorebookEntry{recursive, grouped("dungeon", "place", 20, "dungeon")},
+			"the castle", []string{"castle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{}, tt.entries...)
+			got := activatedNames(t, c, testChat(tt.text), ApplyOptions{})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInclusionGroupsWeighted(t *testing.T) {
+	entries := make([]ccv3.LorebookEntry, 0)
+	for _, name := range []string{"a", "b", "c"} {
+		entry := testEntry(name, "dragon")
+		entry.Extensions.Group = "g"
+		entries = append(entries, entry)
+	}
+	c := newTestCard(t, ccv3.CharacterCardData{}, entries...)
+	seen := make(map[string]bool)
+	for range 100 {
+		got := activatedNames(t, c, testChat("a dragon"), ApplyOptions{})
+		if len(got) != 1 {
+			t.Fatalf("activated = %v, want exactly one entry", got)
+		}
+		seen[got[0]] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("weighted random always picked %v", seen)
+	}
+}
@@ -0,0 +1,225 @@
+package st
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+// names_behavior 的取值
+const (
+	NamesBehaviorNone   = "none"   // 不添加说话人名称
+	NamesBehaviorForce  = "force"  // 仅为带 name 的消息添加说话人名称
+	NamesBehaviorAlways = "always" // 总是为用户与角色消息添加说话人名称
+)
+
+// InstructTemplate 定义文本补全使用的指令模板，字段与 SillyTavern 的 instruct 模板一致
+type InstructTemplate struct {
+	Name                   string `json:"name"`
+	InputSequence          string `json:"input_sequence"`
+	InputSuffix            string `json:"input_suffix"`
+	OutputSequence         string `json:"output_sequence"`
+	OutputSuffix           string `json:"output_suffix"`
+	SystemSequence         string `json:"system_sequence"`
+	SystemSuffix           string `json:"system_suffix"`
+	FirstOutputSequence    string `json:"first_output_sequence"`
+	LastOutputSequence     string `json:"last_output_sequence"`
+	StopSequence           string `json:"stop_sequence"`
+	Wrap                   bool   `json:"wrap"`                      // 在序列与内容之间插入换行
+	NamesBehavior          string `json:"names_behavior"`            // none, force, always
+	SystemSameAsUser       bool   `json:"system_same_as_user"`       // system 消息使用用户序列
+	SequencesAsStopStrings bool   `json:"sequences_as_stop_strings"` // 将输入与系统序列作为停止字符串
+}
+
+// BuiltinInstructTemplates 返回内置的指令模板
+func BuiltinInstructTemplates() map[string]*InstructTemplate {
+	return map[string]*InstructTemplate{
+		"ChatML": {
+			Name:                   "ChatML",
+			InputSequence:          "<|im_start|>user",
+			InputSuffix:            "<|im_end|>\n",
+			OutputSequence:         "<|im_start|>assistant",
+			OutputSuffix:           "<|im_end|>\n",
+			SystemSequence:         "<|im_start|>system",
+			SystemSuffix:           "<|im_end|>\n",
+			StopSequence:           "<|im_end|>",
+			Wrap:                   true,
+			NamesBehavior:          NamesBehaviorForce,
+			SequencesAsStopStrings: true,
+		},
+		"Llama 3": {
+			Name:                   "Llama 3",
+			InputSequence:          "<|start_header_id|>user<|end_header_id|>\n\n",
+			InputSuffix:            "<|eot_id|>",
+			OutputSequence:         "<|start_header_id|>assistant<|end_header_id|>\n\n",
+			OutputSuffix:           "<|eot_id|>",
+			SystemSequence:         "<|start_header_id|>system<|end_header_id|>\n\n",
+			SystemSuffix:           "<|eot_id|>",
+			StopSequence:           "<|eot_id|>",
+			NamesBehavior:          NamesBehaviorForce,
+			SequencesAsStopStrings: true,
+		},
+		"Alpaca": {
+			Name:                   "Alpaca",
+			InputSequence:          "### Instruction:",
+			InputSuffix:            "\n\n",
+			OutputSequence:         "### Response:",
+			OutputSuffix:           "\n\n",
+			SystemSuffix:           "\n\n",
+			Wrap:                   true,
+			NamesBehavior:          NamesBehaviorForce,
+			SequencesAsStopStrings: true,
+		},
+		"Mistral": {
+			Name:                   "Mistral",
+			InputSequence:          "[INST] ",
+			InputSuffix:            " [/INST]",
+			OutputSuffix:           "</s>",
+			StopSequence:           "</s>",
+			NamesBehavior:          NamesBehaviorForce,
+			SystemSameAsUser:       true,
+			SequencesAsStopStrings: true,
+		},
+		"Vicuna": {
+			Name:                   "Vicuna",
+			InputSequence:          "USER: ",
+			InputSuffix:            "\n",
+			OutputSequence:         "ASSISTANT: ",
+			OutputSuffix:           "</s>\n",
+			SystemSuffix:           "\n\n",
+			StopSequence:           "</s>",
+			NamesBehavior:          NamesBehaviorForce,
+			SequencesAsStopStrings: true,
+		},
+	}
+}
+
+// ParseInstructTemplate 解析 SillyTavern 的 instruct 模板文件
+func ParseInstructTemplate(data []byte) (*InstructTemplate, error) {
+	t := &InstructTemplate{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	switch t.NamesBehavior {
+	case "":
+		t.NamesBehavior = NamesBehaviorForce
+	case NamesBehaviorNone, NamesBehaviorForce, NamesBehaviorAlways:
+	default:
+		return nil, fmt.Errorf("invalid names_behavior: %s", t.NamesBehavior)
+	}
+	return t, nil
+}
+
+// StopStrings 返回文本补全时使用的停止字符串
+func (t *InstructTemplate) StopStrings() []string {
+	stops := make([]string, 0, 3)
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		if s != "" && !slices.Contains(stops, s) {
+			stops = append(stops, s)
+		}
+	}
+	add(t.StopSequence)
+	if t.SequencesAsStopStrings {
+		add(t.InputSequence)
+		add(t.SystemSequence)
+	}
+	return stops
+}
+
+// render 使用指令模板将消息数组渲染为单个文本提示。
+// 默认以助手序列结尾，由模型写出下一条助手消息；impersonate 时以用户序列结尾；
+// 存在 continue 续写的助手消息时，将其放在末尾且不添加后缀，由模型接着写下去。
+func (t *InstructTemplate) render(messages []messageType, userName string, charName string, generationType string) string {
+	separator := ""
+	if t.Wrap {
+		separator = "\n"
+	}
+	name := func(msg messageType) string {
+		switch {
+		case t.NamesBehavior == NamesBehaviorNone:
+			return ""
+		case msg.Name != "":
+			return msg.Name
+		case t.NamesBehavior != NamesBehaviorAlways:
+			return ""
+		case msg.Role == user:
+			return userName
+		case msg.Role == assistant:
+			return charName
+		default:
+			return ""
+		}
+	}
+	write := func(sb *strings.Builder, prefix string, content string, suffix string) {
+		if prefix != "" {
+			sb.WriteString(prefix)
+			sb.WriteString(separator)
+		}
+		sb.WriteString(content)
+		sb.WriteString(suffix)
+	}
+
+	sb := strings.Builder{}
+	firstOutput := true
+	outputSequence := func(last bool) string {
+		sequence := t.OutputSequence
+		switch {
+		case last && t.LastOutputSequence != "":
+			sequence = t.LastOutputSequence
+		case !last && firstOutput && t.FirstOutputSequence != "":
+			sequence = t.FirstOutputSequence
+		}
+		firstOutput = false
+		return sequence
+	}
+	var continued *messageType
+	for _, msg := range messages {
+		if msg.continued {
+			continued = &msg
+			continue
+		}
+		content := msg.Content
+		if n := name(msg); n != "" {
+			content = n + ": " + content
+		}
+		switch msg.Role {
+		case user:
+			write(&sb, t.InputSequence, content, t.InputSuffix)
+		case assistant:
+			write(&sb, outputSequence(false), content, t.OutputSuffix)
+		default:
+			if t.SystemSameAsUser {
+				write(&sb, t.InputSequence, content, t.InputSuffix)
+			} else {
+				write(&sb, t.SystemSequence, content, t.SystemSuffix)
+			}
+		}
+	}
+
+	switch {
+	case continued != nil:
+		// Leave the continued message open so the model picks up where it stopped
+		content := continued.Content
+		if n := name(*continued); n != "" {
+			content = n + ": " + content
+		}
+		write(&sb, outputSequence(true), content, "")
+	case generationType == ccv3.TriggerImpersonate:
+		// Prompt the model to write the next user message
+		write(&sb, t.InputSequence, "", "")
+		if t.NamesBehavior == NamesBehaviorAlways {
+			sb.WriteString(userName + ":")
+		}
+	default:
+		// Prompt the model to write the next assistant message
+		write(&sb, outputSequence(true), "", "")
+		if t.NamesBehavior == NamesBehaviorAlways {
+			sb.WriteString(charName + ":")
+		}
+	}
+	return sb.String()
+}
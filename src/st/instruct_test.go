@@ -0,0 +1,167 @@
+package st
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestInstructRender(t *testing.T) {
+	builtin := BuiltinInstructTemplates()
+	messages := []messageType{
+		{Role: system, Content: "Be nice."},
+		{Role: assistant, Content: "Hello!"},
+		{Role: user, Content: "Hi.", Name: "Bob"},
+	}
+	tests := []struct {
+		name     string
+		template *InstructTemplate
+		want     string
+	}{
+		{
+			"ChatML", builtin["ChatML"],
+			"<|im_start|>system\nBe nice.<|im_end|>\n" +
+				"<|im_start|>assistant\nHello!<|im_end|>\n" +
+				"<|im_start|>user\nBob: Hi.<|im_end|>\n" +
+				"<|im_start|>assistant\n",
+		},
+		{
+			"Llama 3", builtin["Llama 3"],
+			"<|start_header_id|>system<|end_header_id|>\n\nBe nice.<|eot_id|>" +
+				"<|start_header_id|>assistant<|end_header_id|>\n\nHello!<|eot_id|>" +
+				"<|start_header_id|>user<|end_header_id|>\n\nBob: Hi.<|eot_id|>" +
+				"<|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			"Mistral system as user", builtin["Mistral"],
+			"[INST] Be nice. [/INST]Hello!</s>[INST] Bob: Hi. [/INST]",
+		},
+		{
+			"names always, first and last output sequences",
+			&InstructTemplate{
+				InputSequence:       "U:",
+				OutputSequence:      "A:",
+				FirstOutputSequence: "A1:",
+				LastOutputSequence:  "AL:",
+				InputSuffix:         "\n",
+				OutputSuffix:        "\n",
+				SystemSuffix:        "\n",
+				NamesBehavior:       NamesBehaviorAlways,
+			},
+			"Be nice.\nA1:Alice: Hello!\nU:Bob: Hi.\nAL:Alice:",
+		},
+		{
+			"names none",
+			&InstructTemplate{InputSequence: "U:", OutputSequence: "A:", NamesBehavior: NamesBehaviorNone},
+			"Be nice.A:Hello!U:Hi.A:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.template.render(messages, "Bob", "Alice", ""); got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstructRenderGenerationTypes(t *testing.T) {
+	template := &InstructTemplate{
+		InputSequence:      "U:",
+		OutputSequence:     "A:",
+		LastOutputSequence: "AL:",
+		InputSuffix:        "\n",
+		OutputSuffix:       "\n",
+		SystemSuffix:       "\n",
+		NamesBehavior:      NamesBehaviorAlways,
+	}
+	chat := []messageType{
+		{Role: user, Content: "Hi."},
+		{Role: assistant, Content: "Hello, I am"},
+	}
+	continued := []messageType{
+		chat[0],
+		{Role: assistant, Content: "Hello, I am", continued: true},
+		{Role: system, Content: "Stay in character."},
+	}
+	tests := []struct {
+		name           string
+		messages       []messageType
+		generationType string
+		want           string
+	}{
+		{"normal", chat, "", "U:Bob: Hi.\nA:Alice: Hello, I am\nAL:Alice:"},
+		{"continue leaves the message open at the end", continued, ccv3.TriggerContinue, "U:Bob: Hi.\nStay in character.\nAL:Alice: Hello, I am"},
+		{"impersonate", chat, ccv3.TriggerImpersonate, "U:Bob: Hi.\nA:Alice: Hello, I am\nU:Bob:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := template.render(tt.messages, "Bob", "Alice", tt.generationType); got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTextContinue(t *testing.T) {
+	c := newTestCard(t, ccv3.CharacterCardData{})
+	c.PostHistoryInstructions = "Stay in character."
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "Hi."},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Hello, I am"},
+	}
+	prompt, _, err := c.ApplyText(messages, BuiltinInstructTemplates()["ChatML"], ApplyOptions{GenerationType: ccv3.TriggerContinue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<|im_start|>system\nStay in character.<|im_end|>\n<|im_start|>assistant\nHello, I am"; !strings.HasSuffix(prompt, want) {
+		t.Errorf("prompt = %q, want suffix %q", prompt, want)
+	}
+}
+
+func TestInstructStopStrings(t *testing.T) {
+	builtin := BuiltinInstructTemplates()
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"ChatML", []string{"<|im_end|>", "<|im_start|>user", "<|im_start|>system"}},
+		{"Alpaca", []string{"### Instruction:"}},
+		{"Mistral", []string{"</s>", "[INST]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := builtin[tt.name].StopStrings(); !slices.Equal(got, tt.want) {
+				t.Errorf("StopStrings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInstructTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantNames string
+		wantErr   bool
+	}{
+		{"default names behavior", `{"name":"t","input_sequence":"U:"}`, NamesBehaviorForce, false},
+		{"always", `{"name":"t","names_behavior":"always"}`, NamesBehaviorAlways, false},
+		{"invalid names behavior", `{"name":"t","names_behavior":"sometimes"}`, "", true},
+		{"invalid JSON", `{`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInstructTemplate([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInstructTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.NamesBehavior != tt.wantNames {
+				t.Errorf("NamesBehavior = %q, want %q", got.NamesBehavior, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package st
+
+import (
+	"fmt"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/rs/zerolog/log"
+)
+
+// Lorebook 定义一个可附加到角色卡的独立设定集
+type Lorebook interface {
+	// GetName 返回设定集名称
+	GetName() string
+	// GetData 返回设定集的完整数据
+	GetData() ccv3.Lorebook
+}
+
+// NewLorebook 解析并返回一个新的 Lorebook 实例
+func NewLorebook(name string, data ccv3.Lorebook) (Lorebook, error) {
+	book, err := newLorebook(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+type lorebookType struct {
+	name    string
+	data    ccv3.Lorebook
+	entries lorebookEntriesType
+}
+
+func newLorebook(name string, data ccv3.Lorebook) (*lorebookType, error) {
+	book := &lorebookType{
+		name: name,
+		data: data,
+	}
+	entries, err := newLorebookEntriesFromCCV3(data.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lorebook %s entries: %w", name, err)
+	}
+	for i := range entries {
+		entries[i].book = book
+	}
+	book.entries = entries
+	log.Debug().Str("name", name).Int("count", len(entries)).Msg("Lorebook entries loaded")
+	return book, nil
+}
+
+func (l *lorebookType) GetName() string {
+	return l.name
+}
+
+func (l *lorebookType) GetData() ccv3.Lorebook {
+	return l.data
+}
+
+// mergeLorebooks 合并角色卡设定集与附加的设定集，每个设定集只会出现一次
+func (c *cardType) mergeLorebooks(books []Lorebook) lorebookEntriesType {
+	merged := c.lorebook.Copy()
+	seen := make(map[*lorebookType]struct{}, len(books))
+	for _, b := range books {
+		book, ok := b.(*lorebookType)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[book]; ok {
+			continue
+		}
+		seen[book] = struct{}{}
+		merged.Push(book.entries...)
+	}
+	return merged
+}
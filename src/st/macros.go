@@ -5,20 +5,41 @@ import (
 )
 
 func (c *cardType) evalMacros(prompt string) string {
-	staticMacros := newCaseInsensitiveReplacer(
+	return c.macroReplacer().Replace(prompt)
+}
+
+// macroReplacer 返回替换静态宏的 replacer，需要多次替换时复用以避免重复编译正则
+func (c *cardType) macroReplacer() *caseInsensitiveReplacer {
+	return newCaseInsensitiveReplacer(
 		"{{newline}}", "\n",
 		"{{noop}}", "",
 		"{{user}}", c.UserName,
 		"<USER>", c.UserName,
-		"{{char}}", c.data.Name,
-		"<BOT>", c.data.Name,
+		"{{char}}", c.charName(),
+		"<BOT>", c.charName(),
+		"{{charFullName}}", c.data.Name,
 		"{{description}}", c.data.Description,
 		"{{scenario}}", c.data.Scenario,
 		"{{personality}}", c.data.Personality,
 		"{{persona}}", c.UserPersona,
 		"{{mesExamplesRaw}}", c.data.MesExample,
 	)
-	return staticMacros.Replace(prompt)
+}
+
+// charName 返回用于替换 {{char}} 的角色名称，设置了昵称时使用昵称
+func (c *cardType) charName() string {
+	if c.data.Nickname != "" {
+		return c.data.Nickname
+	}
+	return c.data.Name
+}
+
+// replaceOriginal 将 prompt 中的 {{original}} 替换为 original，prompt 为空时直接返回 original
+func replaceOriginal(prompt string, original string) string {
+	if prompt == "" {
+		return original
+	}
+	return newCaseInsensitiveReplacer("{{original}}", original).Replace(prompt)
 }
 
 type caseInsensitiveReplacer struct {
@@ -49,7 +70,7 @@ func newCaseInsensitiveReplacer(oldnew ...string) *caseInsensitiveReplacer {
 
 func (cir *caseInsensitiveReplacer) Replace(str string) string {
 	for i, re := range cir.toReplaces {
-		str = re.ReplaceAllString(str, cir.replaceWiths[i])
+		str = re.ReplaceAllLiteralString(str, cir.replaceWiths[i])
 	}
 	return str
 }
@@ -0,0 +1,111 @@
+package st
+
+import (
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+func TestReplaceOriginal(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompt   string
+		original string
+		want     string
+	}{
+		{"empty prompt", "", "Default", "Default"},
+		{"no placeholder", "Card prompt", "Default", "Card prompt"},
+		{"placeholder", "Card: {{original}}", "Default", "Card: Default"},
+		{"case insensitive", "{{ORIGINAL}} and {{Original}}", "x", "x and x"},
+		{"dollar signs", "Card: {{original}}", "The fee is $5 or ${price}.", "Card: The fee is $5 or ${price}."},
+		{"capture group syntax", "{{original}}", "$0 $1 ${0}", "$0 $1 ${0}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceOriginal(tt.prompt, tt.original); got != tt.want {
+				t.Errorf("replaceOriginal(%q, %q) = %q, want %q", tt.prompt, tt.original, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveReplacer(t *testing.T) {
+	r := newCaseInsensitiveReplacer("{{user}}", "$user", "{{char}}", "${1}Alice")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"{{user}} meets {{char}}", "$user meets ${1}Alice"},
+		{"{{USER}} and {{Char}}", "$user and ${1}Alice"},
+		{"no macros", "no macros"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := r.Replace(tt.in); got != tt.want {
+				t.Errorf("Replace(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCardPrompts(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		setting  string
+		history  bool
+		wantMain string
+	}{
+		{"main prompt from settings", "", "Fee: $5", false, "Fee: $5"},
+		{"card replaces main prompt", "Card prompt", "Fee: $5", false, "Card prompt"},
+		{"card wraps main prompt", "{{original}} (${price})", "Fee: $5", false, "Fee: $5 (${price})"},
+		{"post history from settings", "", "Reply in ${lang}", true, "Reply in ${lang}"},
+		{"card wraps post history", "{{original}}!", "Stay $in character", true, "Stay $in character!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{})
+			if tt.history {
+				c.data.PostHistoryInstructions = tt.data
+				c.PostHistoryInstructions = tt.setting
+			} else {
+				c.data.SystemPrompt = tt.data
+				c.MainPrompt = tt.setting
+			}
+			messages, err := c.apply(testOpenAIChat(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			index := 0
+			if tt.history {
+				index = len(messages) - 1
+			}
+			if got := messages[index]; got.Role != system || got.Content != tt.wantMain {
+				t.Errorf("messages[%d] = %v %q, want system %q", index, got.Role, got.Content, tt.wantMain)
+			}
+		})
+	}
+}
+
+func TestCardMacros(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickname string
+		in       string
+		want     string
+	}{
+		{"char is the name", "", "{{char}} / <BOT> / {{charFullName}}", "Alice Liddell / Alice Liddell / Alice Liddell"},
+		{"char is the nickname", "Alice", "{{char}} / <BOT> / {{charFullName}}", "Alice / Alice / Alice Liddell"},
+		{"case insensitive", "Alice", "{{CHARFULLNAME}} and {{Char}}", "Alice Liddell and Alice"},
+		{"user", "Alice", "{{user}} meets <USER>", "Bob meets Bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{Name: "Alice Liddell", Nickname: tt.nickname})
+			c.UserName = "Bob"
+			if got := c.evalMacros(tt.in); got != tt.want {
+				t.Errorf("evalMacros(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package st
+
+import (
+	"slices"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+// 内置匹配来源的名称
+const (
+	MatchSourcePersona      = "persona"      // 用户人设
+	MatchSourceDescription  = "description"  // 角色描述
+	MatchSourcePersonality  = "personality"  // 角色性格
+	MatchSourceDepthPrompt  = "depthPrompt"  // 角色卡的深度提示词
+	MatchSourceScenario     = "scenario"     // 场景设定
+	MatchSourceCreatorNotes = "creatorNotes" // 作者备注
+)
+
+// MatchContext 是匹配来源读取文本时可用的内容
+type MatchContext struct {
+	Data        ccv3.CharacterCardData
+	UserName    string
+	UserPersona string
+}
+
+// MatchSource 定义 World Info 扫描时聊天记录之外的匹配文本来源
+type MatchSource struct {
+	Name    string                                     // 来源名称
+	Enabled func(ext ccv3.LorebookEntryExtension) bool // 条目是否扫描此来源 (可选, 默认仅在全局启用时扫描)
+	Text    func(ctx MatchContext) string              // 返回用于匹配的文本
+}
+
+// DefaultMatchSources 返回内置的匹配来源
+func DefaultMatchSources() []MatchSource {
+	return []MatchSource{
+		{
+			Name:    MatchSourcePersona,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchPersonaDescription },
+			Text:    func(ctx MatchContext) string { return ctx.UserPersona },
+		},
+		{
+			Name:    MatchSourceDescription,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchCharacterDescription },
+			Text:    func(ctx MatchContext) string { return ctx.Data.Description },
+		},
+		{
+			Name:    MatchSourcePersonality,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchCharacterPersonality },
+			Text:    func(ctx MatchContext) string { return ctx.Data.Personality },
+		},
+		{
+			Name:    MatchSourceDepthPrompt,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchCharacterDepthPrompt },
+			Text:    func(ctx MatchContext) string { return ctx.Data.Extensions.DepthPrompt.Prompt },
+		},
+		{
+			Name:    MatchSourceScenario,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchScenario },
+			Text:    func(ctx MatchContext) string { return ctx.Data.Scenario },
+		},
+		{
+			Name:    MatchSourceCreatorNotes,
+			Enabled: func(ext ccv3.LorebookEntryExtension) bool { return ext.MatchCreatorNotes },
+			Text:    func(ctx MatchContext) string { return ctx.Data.CreatorNotes },
+		},
+	}
+}
+
+// matchSourceText 是已读取文本的匹配来源
+type matchSourceText struct {
+	MatchSource
+	text   string
+	global bool // 是否对所有条目扫描
+}
+
+// buildMatchSources 读取所有匹配来源的文本，文本中的宏会被替换
+func (c *cardType) buildMatchSources() []matchSourceText {
+	ctx := MatchContext{
+		Data:        c.data,
+		UserName:    c.UserName,
+		UserPersona: c.UserPersona,
+	}
+	sources := make([]matchSourceText, 0, len(c.MatchSources))
+	for _, source := range c.MatchSources {
+		text := c.processPrompt(source.Text(ctx))
+		if text == "" {
+			continue
+		}
+		sources = append(sources, matchSourceText{
+			MatchSource: source,
+			text:        text,
+			global:      slices.Contains(c.WorldInfoMatchSources, source.Name),
+		})
+	}
+	return sources
+}
+
+// enabledFor 返回条目是否扫描此来源
+func (s *matchSourceText) enabledFor(e lorebookEntryType) bool {
+	return s.global || (s.Enabled != nil && s.Enabled(e.LorebookEntryExtension))
+}
@@ -9,6 +9,9 @@ import (
 type messageType struct {
 	Role    roleType
 	Content string
+	Name    string
+
+	continued bool // 是否为 continue 续写的助手消息，文本补全时保持该消息未结束
 }
 
 func parseOpenAIMessage(msg openai.ChatCompletionMessage) (messageType, error) {
@@ -19,6 +22,7 @@ func parseOpenAIMessage(msg openai.ChatCompletionMessage) (messageType, error) {
 	return messageType{
 		Role:    role,
 		Content: msg.Content,
+		Name:    msg.Name,
 	}, nil
 }
 
@@ -26,6 +30,7 @@ func (msg messageType) ToOpenAIMessage() openai.ChatCompletionMessage {
 	return openai.ChatCompletionMessage{
 		Role:    msg.Role.ToOpenAIRole(),
 		Content: msg.Content,
+		Name:    msg.Name,
 	}
 }
 
@@ -0,0 +1,163 @@
+package st
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Preset 是从 SillyTavern 聊天补全预设转换而来的提示词组装设置与采样参数
+type Preset struct {
+	Name                 string
+	PromptOrder          []PromptBlock
+	NewMainChat          string
+	NewExampleChat       string
+	SquashSystemMessages bool
+	Sampling             SamplingParams
+}
+
+// SamplingParams 定义请求上游模型时使用的采样参数，未设置的参数使用默认值
+type SamplingParams struct {
+	Temperature      *float32
+	TopP             *float32
+	FrequencyPenalty *float32
+	PresencePenalty  *float32
+	MaxTokens        *int
+}
+
+// sillyTavernPreset 是 SillyTavern 聊天补全预设文件的结构
+type sillyTavernPreset struct {
+	Temperature          *float32                 `json:"temperature"`
+	TopP                 *float32                 `json:"top_p"`
+	FrequencyPenalty     *float32                 `json:"frequency_penalty"`
+	PresencePenalty      *float32                 `json:"presence_penalty"`
+	MaxTokens            *int                     `json:"openai_max_tokens"`
+	SquashSystemMessages bool                     `json:"squash_system_messages"`
+	NewChatPrompt        string                   `json:"new_chat_prompt"`
+	NewExampleChatPrompt string                   `json:"new_example_chat_prompt"`
+	Prompts              []sillyTavernPrompt      `json:"prompts"`
+	PromptOrder          []sillyTavernPromptOrder `json:"prompt_order"`
+}
+
+type sillyTavernPrompt struct {
+	Identifier        string `json:"identifier"`
+	Name              string `json:"name"`
+	Role              string `json:"role"`
+	Content           string `json:"content"`
+	Marker            bool   `json:"marker"`
+	InjectionPosition int    `json:"injection_position"` // 0 为按顺序排列，1 为按深度插入
+	InjectionDepth    int    `json:"injection_depth"`
+}
+
+type sillyTavernPromptOrder struct {
+	CharacterID int `json:"character_id"`
+	Order       []struct {
+		Identifier string `json:"identifier"`
+		Enabled    bool   `json:"enabled"`
+	} `json:"order"`
+}
+
+// SillyTavern 在 prompt_order 中使用的全局角色ID
+const (
+	sillyTavernGlobalCharacterID = 100001
+	sillyTavernLegacyCharacterID = 100000
+)
+
+// ParsePreset 解析 SillyTavern 聊天补全预设文件
+func ParsePreset(name string, data []byte) (*Preset, error) {
+	raw := sillyTavernPreset{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	preset := &Preset{
+		Name:                 name,
+		NewMainChat:          raw.NewChatPrompt,
+		NewExampleChat:       raw.NewExampleChatPrompt,
+		SquashSystemMessages: raw.SquashSystemMessages,
+		Sampling: SamplingParams{
+			Temperature:      raw.Temperature,
+			TopP:             raw.TopP,
+			FrequencyPenalty: raw.FrequencyPenalty,
+			PresencePenalty:  raw.PresencePenalty,
+			MaxTokens:        raw.MaxTokens,
+		},
+	}
+
+	order := raw.promptOrder()
+	if order == nil {
+		log.Debug().Str("preset", name).Msg("Preset has no prompt_order, using default")
+		return preset, nil
+	}
+	prompts := make(map[string]sillyTavernPrompt, len(raw.Prompts))
+	for _, prompt := range raw.Prompts {
+		prompts[prompt.Identifier] = prompt
+	}
+	hasAuthorsNote := false
+	for _, item := range order.Order {
+		prompt, ok := prompts[item.Identifier]
+		if !ok {
+			log.Warn().Str("preset", name).Str("identifier", item.Identifier).Msg("Prompt in prompt_order not found, skipping")
+			continue
+		}
+		block := PromptBlock{
+			Identifier: prompt.Identifier,
+			Enabled:    item.Enabled,
+			Role:       prompt.Role,
+		}
+		if !prompt.Marker {
+			block.Content = prompt.Content
+		}
+		if prompt.InjectionPosition == 1 {
+			depth := prompt.InjectionDepth
+			block.InjectionDepth = &depth
+		}
+		if block.Identifier == PromptAuthorsNote {
+			hasAuthorsNote = true
+		}
+		preset.PromptOrder = append(preset.PromptOrder, block)
+	}
+	// SillyTavern does not manage the author's note in the prompt order
+	if !hasAuthorsNote {
+		for _, block := range DefaultPromptOrder() {
+			if block.Identifier == PromptAuthorsNote {
+				preset.PromptOrder = append(preset.PromptOrder, block)
+			}
+		}
+	}
+	if err := ValidatePromptOrder(preset.PromptOrder); err != nil {
+		return nil, err
+	}
+	log.Debug().Str("preset", name).Int("blocks", len(preset.PromptOrder)).Msg("Preset loaded")
+	return preset, nil
+}
+
+// promptOrder 返回全局的提示词顺序
+func (p *sillyTavernPreset) promptOrder() *sillyTavernPromptOrder {
+	for _, id := range []int{sillyTavernGlobalCharacterID, sillyTavernLegacyCharacterID} {
+		for i := range p.PromptOrder {
+			if p.PromptOrder[i].CharacterID == id {
+				return &p.PromptOrder[i]
+			}
+		}
+	}
+	if len(p.PromptOrder) > 0 {
+		return &p.PromptOrder[0]
+	}
+	return nil
+}
+
+// ApplyTo 返回应用了预设的角色卡设置
+func (p *Preset) ApplyTo(settings CardSettings) CardSettings {
+	if p.PromptOrder != nil {
+		settings.PromptOrder = p.PromptOrder
+	}
+	if p.NewMainChat != "" {
+		settings.NewMainChat = p.NewMainChat
+	}
+	if p.NewExampleChat != "" {
+		settings.NewExampleChat = p.NewExampleChat
+	}
+	settings.SquashSystemMessages = p.SquashSystemMessages
+	return settings
+}
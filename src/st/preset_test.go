@@ -0,0 +1,147 @@
+package st
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParsePreset(t *testing.T) {
+	const prompts = `"prompts": [
+		{"identifier": "main", "role": "system", "content": "Preset main"},
+		{"identifier": "chatHistory", "marker": true, "content": "ignored"},
+		{"identifier": "charDescription", "marker": true},
+		{"identifier": "nsfw", "role": "user", "content": "Custom", "injection_position": 1, "injection_depth": 2},
+		{"identifier": "jailbreak", "role": "system", "content": "Preset post"}
+	]`
+	tests := []struct {
+		name      string
+		data      string
+		wantOrder []string // 启用的块标识，nil 表示使用默认顺序
+		wantErr   bool
+	}{
+		{
+			"global order",
+			`{` + prompts + `, "prompt_order": [
+				{"character_id": 100000, "order": [{"identifier": "chatHistory", "enabled": true}]},
+				{"character_id": 100001, "order": [
+					{"identifier": "main", "enabled": true},
+					{"identifier": "charDescription", "enabled": false},
+					{"identifier": "missing", "enabled": true},
+					{"identifier": "chatHistory", "enabled": true},
+					{"identifier": "nsfw", "enabled": true},
+					{"identifier": "jailbreak", "enabled": true}
+				]}
+			]}`,
+			[]string{"main", "chatHistory", "nsfw", "jailbreak", "authorsNote"}, false,
+		},
+		{
+			"legacy order",
+			`{` + prompts + `, "prompt_order": [{"character_id": 100000, "order": [{"identifier": "chatHistory", "enabled": true}]}]}`,
+			[]string{"chatHistory", "authorsNote"}, false,
+		},
+		{"no prompt order", `{"temperature": 0.7}`, nil, false},
+		{
+			"no chat history",
+			`{` + prompts + `, "prompt_order": [{"character_id": 100001, "order": [{"identifier": "main", "enabled": true}]}]}`,
+			nil, true,
+		},
+		{"invalid JSON", `{`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, err := ParsePreset("test", []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePreset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantOrder == nil {
+				if preset.PromptOrder != nil {
+					t.Errorf("PromptOrder = %v, want nil", preset.PromptOrder)
+				}
+				return
+			}
+			enabled := make([]string, 0)
+			for _, block := range preset.PromptOrder {
+				if block.Enabled {
+					enabled = append(enabled, block.Identifier)
+				}
+			}
+			if !slices.Equal(enabled, tt.wantOrder) {
+				t.Errorf("enabled blocks = %v, want %v", enabled, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestParsePresetBlocks(t *testing.T) {
+	data := `{
+		"temperature": 0.7, "openai_max_tokens": 300, "squash_system_messages": true,
+		"new_chat_prompt": "[New]",
+		"prompts": [
+			{"identifier": "main", "role": "system", "content": "Preset main"},
+			{"identifier": "chatHistory", "marker": true, "content": "ignored"},
+			{"identifier": "nsfw", "role": "user", "content": "Custom", "injection_position": 1, "injection_depth": 2}
+		],
+		"prompt_order": [{"character_id": 100001, "order": [
+			{"identifier": "main", "enabled": true},
+			{"identifier": "chatHistory", "enabled": true},
+			{"identifier": "nsfw", "enabled": true}
+		]}]
+	}`
+	preset, err := ParsePreset("test", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset.Name != "test" || preset.NewMainChat != "[New]" || !preset.SquashSystemMessages {
+		t.Errorf("preset = %+v", preset)
+	}
+	if s := preset.Sampling; s.Temperature == nil || *s.Temperature != 0.7 || s.MaxTokens == nil || *s.MaxTokens != 300 || s.TopP != nil {
+		t.Errorf("Sampling = %+v", s)
+	}
+	main, history, custom := preset.PromptOrder[0], preset.PromptOrder[1], preset.PromptOrder[2]
+	if main.Content != "Preset main" || main.Role != "system" || main.InjectionDepth != nil {
+		t.Errorf("main block = %+v", main)
+	}
+	if history.Content != "" {
+		t.Errorf("marker block content = %q, want empty", history.Content)
+	}
+	if custom.Role != "user" || custom.InjectionDepth == nil || *custom.InjectionDepth != 2 {
+		t.Errorf("custom block = %+v", custom)
+	}
+}
+
+func TestPresetApplyTo(t *testing.T) {
+	order := []PromptBlock{{Identifier: PromptChatHistory, Enabled: true}}
+	settings := CardSettings{
+		UserName:             "Bob",
+		NewMainChat:          "[Card chat]",
+		NewExampleChat:       "[Card example]",
+		PromptOrder:          DefaultPromptOrder(),
+		SquashSystemMessages: true,
+		MainPrompt:           "Main",
+	}
+	tests := []struct {
+		name   string
+		preset Preset
+		want   CardSettings
+	}{
+		{
+			"empty preset keeps settings except squashing", Preset{},
+			CardSettings{UserName: "Bob", NewMainChat: "[Card chat]", NewExampleChat: "[Card example]", PromptOrder: DefaultPromptOrder(), MainPrompt: "Main"},
+		},
+		{
+			"preset wins", Preset{PromptOrder: order, NewMainChat: "[Preset chat]", NewExampleChat: "[Preset example]", SquashSystemMessages: true},
+			CardSettings{UserName: "Bob", NewMainChat: "[Preset chat]", NewExampleChat: "[Preset example]", PromptOrder: order, SquashSystemMessages: true, MainPrompt: "Main"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.preset.ApplyTo(settings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyTo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package st
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 内置提示词块的标识，与 SillyTavern 提示词管理器保持一致
+const (
+	PromptMain            = "main"               // 主提示词 (角色卡 system_prompt)
+	PromptWorldInfoBefore = "worldInfoBefore"    // 角色定义之前的 World Info
+	PromptPersona         = "personaDescription" // 用户人设
+	PromptDescription     = "charDescription"    // 角色描述
+	PromptPersonality     = "charPersonality"    // 角色性格
+	PromptScenario        = "scenario"           // 场景设定
+	PromptWorldInfoAfter  = "worldInfoAfter"     // 角色定义之后的 World Info
+	PromptExamples        = "dialogueExamples"   // 示例对话 (含示例对话前后的 World Info)
+	PromptChatHistory     = "chatHistory"        // 聊天记录 (含按深度插入的 World Info)
+	PromptAuthorsNote     = "authorsNote"        // 作者注释 (含作者注释顶部与底部的 World Info)
+	PromptPostHistory     = "jailbreak"          // 后历史指令 (角色卡 post_history_instructions)
+)
+
+// PromptBlock 定义提示词顺序中的一个块
+type PromptBlock struct {
+	Identifier     string `json:"identifier"`                // 块标识，非内置标识的块为自定义块
+	Enabled        bool   `json:"enabled"`                   // 是否启用
+	Role           string `json:"role,omitempty"`            // 消息角色 system, user, assistant (可选, 默认 system)
+	Content        string `json:"content,omitempty"`         // 自定义块的内容；对 main 与 jailbreak 为替换 {{original}} 的默认内容；对 authorsNote 为作者注释
+	InjectionDepth *int   `json:"injection_depth,omitempty"` // 设置时按该深度插入聊天记录中，而非按顺序排列 (可选)
+}
+
+// DefaultPromptOrder 返回默认的提示词顺序
+func DefaultPromptOrder() []PromptBlock {
+	authorsNoteDepth := 4
+	return []PromptBlock{
+		{Identifier: PromptMain, Enabled: true},
+		{Identifier: PromptWorldInfoBefore, Enabled: true},
+		{Identifier: PromptPersona, Enabled: true},
+		{Identifier: PromptDescription, Enabled: true},
+		{Identifier: PromptPersonality, Enabled: true},
+		{Identifier: PromptScenario, Enabled: true},
+		{Identifier: PromptWorldInfoAfter, Enabled: true},
+		{Identifier: PromptExamples, Enabled: true},
+		{Identifier: PromptChatHistory, Enabled: true},
+		{Identifier: PromptAuthorsNote, Enabled: true, InjectionDepth: &authorsNoteDepth},
+		{Identifier: PromptPostHistory, Enabled: true},
+	}
+}
+
+// ValidatePromptOrder 检查提示词顺序中恰好有一个启用的聊天记录块，否则对话会被丢弃或重复；
+// 同时检查插入深度不为负数，否则该块不会插入聊天记录中
+func ValidatePromptOrder(order []PromptBlock) error {
+	count := 0
+	for _, block := range order {
+		if block.Enabled && block.Identifier == PromptChatHistory {
+			count++
+		}
+		if block.InjectionDepth != nil && *block.InjectionDepth < 0 {
+			return fmt.Errorf("prompt block %s: injection_depth must not be negative, got %d", block.Identifier, *block.InjectionDepth)
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("prompt order must contain exactly one enabled %s block, got %d", PromptChatHistory, count)
+	}
+	return nil
+}
+
+func (b *PromptBlock) role() (roleType, error) {
+	if b.Role == "" {
+		return system, nil
+	}
+	role, err := parseOpenAIRole(b.Role)
+	if err != nil {
+		return 0, fmt.Errorf("prompt block %s: %w", b.Identifier, err)
+	}
+	return role, nil
+}
+
+// buildPrompt 按提示词顺序组装完整的消息数组，设置 budget 时按上下文预算裁剪
+func (c *cardType) buildPrompt(history []messageType, wi *worldInfoType, budget *ContextBudget, report *ApplyReport) ([]messageType, error) {
+	if wi == nil {
+		wi = &worldInfoType{}
+	}
+	ev := log.Debug()
+
+	blocks := make([][]messageType, len(c.PromptOrder))
+	injections := make(map[int][]messageType)
+	for i, block := range c.PromptOrder {
+		if !block.Enabled || block.Identifier == PromptChatHistory {
+			continue
+		}
+		role, err := block.role()
+		if err != nil {
+			return nil, err
+		}
+		messages := c.buildPromptBlock(block, role, wi)
+		if block.InjectionDepth != nil {
+			injections[*block.InjectionDepth] = append(injections[*block.InjectionDepth], messages...)
+			continue
+		}
+		blocks[i] = messages
+	}
+	if budget != nil && budget.ContextSize > 0 {
+		history = c.fitContext(history, blocks, wi, injections, budget, report)
+	}
+
+	messages := make([]messageType, 0, len(history))
+	for i, block := range c.PromptOrder {
+		if !block.Enabled {
+			continue
+		}
+		if block.Identifier == PromptChatHistory {
+			mainChat := c.buildMainChat(history, wi, injections)
+			messages = append(messages, mainChat...)
+			ev.Int(block.Identifier, len(mainChat))
+			continue
+		}
+		messages = append(messages, blocks[i]...)
+		ev.Int(block.Identifier, len(blocks[i]))
+	}
+
+	if c.SquashSystemMessages {
+		messages = c.squashSystemMessages(messages)
+	}
+
+	ev.Int("activated", wi.ActivatedCount()).
+		Int("total", len(messages)).
+		Msg("Prompt built")
+	return messages, nil
+}
+
+// buildPromptBlock 生成除聊天记录外的单个提示词块
+func (c *cardType) buildPromptBlock(block PromptBlock, role roleType, wi *worldInfoType) []messageType {
+	messages := make([]messageType, 0)
+	switch block.Identifier {
+	case PromptMain:
+		c.pushPrompt(&messages, role, replaceOriginal(c.data.SystemPrompt, cmp.Or(block.Content, c.MainPrompt)))
+	case PromptWorldInfoBefore:
+		c.applyLorebookEntries(&messages, wi.BeforeCharDefs)
+	case PromptPersona:
+		c.pushPrompt(&messages, role, c.UserPersona)
+	case PromptDescription:
+		c.pushPrompt(&messages, role, c.data.Description)
+	case PromptPersonality:
+		c.pushPrompt(&messages, role, c.data.Personality)
+	case PromptScenario:
+		c.pushPrompt(&messages, role, c.data.Scenario)
+	case PromptWorldInfoAfter:
+		c.applyLorebookEntries(&messages, wi.AfterCharDefs)
+	case PromptExamples:
+		messages = append(messages, c.buildExampleMessages(c.joinLorebookEntries(wi.BeforeExampleMessages))...)
+		messages = append(messages, c.buildExampleMessages(c.data.MesExample)...)
+		messages = append(messages, c.buildExampleMessages(c.joinLorebookEntries(wi.AfterExampleMessages))...)
+	case PromptAuthorsNote:
+		authorsNote := append(wi.TopOfAuthorsNote.Contents(), block.Content)
+		authorsNote = append(authorsNote, wi.BottomOfAuthorsNote.Contents()...)
+		c.pushPrompt(&messages, role, joinNonEmpty(authorsNote, "\n"))
+	case PromptPostHistory:
+		c.pushPrompt(&messages, role, replaceOriginal(c.data.PostHistoryInstructions, cmp.Or(block.Content, c.PostHistoryInstructions)))
+	default:
+		c.pushPrompt(&messages, role, block.Content)
+	}
+	return messages
+}
+
+// buildMainChat 生成聊天记录，并将 World Info 与提示词块按深度插入
+func (c *cardType) buildMainChat(history []messageType, wi *worldInfoType, injections map[int][]messageType) []messageType {
+	messages := make([]messageType, 0, len(history))
+	c.pushPrompt(&messages, system, c.NewMainChat)
+	depths := make([]int, 0, len(injections))
+	for depth := range injections {
+		depths = append(depths, depth)
+	}
+	// Deeper injections come first
+	slices.Sort(depths)
+	slices.Reverse(depths)
+	inject := func(min, max int) {
+		c.applyLorebookEntries(&messages, wi.AtDepth.Depth(min, max))
+		for _, depth := range depths {
+			if depth >= min && (max < 0 || depth <= max) {
+				messages = append(messages, injections[depth]...)
+			}
+		}
+	}
+	for i, msg := range history {
+		if i == 0 {
+			inject(len(history), -1)
+		}
+		depth := len(history) - i - 1
+		messages = append(messages, msg)
+		inject(depth, depth)
+	}
+	return messages
+}
+
+// squashSystemMessages 合并相邻的 system 消息，带 name 的示例消息与聊天分隔提示不参与合并
+func (c *cardType) squashSystemMessages(messages []messageType) []messageType {
+	squashable := func(msg messageType) bool {
+		return msg.Role == system && msg.Name == "" &&
+			msg.Content != c.processPrompt(c.NewMainChat) && msg.Content != c.processPrompt(c.NewExampleChat)
+	}
+	squashed := make([]messageType, 0, len(messages))
+	for _, msg := range messages {
+		if n := len(squashed); n > 0 && squashable(msg) && squashable(squashed[n-1]) {
+			squashed[n-1].Content += "\n" + msg.Content
+			continue
+		}
+		squashed = append(squashed, msg)
+	}
+	return squashed
+}
+
+func joinNonEmpty(elems []string, sep string) string {
+	nonEmpty := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if strings.Trim(elem, " \r\n") != "" {
+			nonEmpty = append(nonEmpty, elem)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
@@ -0,0 +1,114 @@
+package st
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+func TestValidatePromptOrder(t *testing.T) {
+	history := PromptBlock{Identifier: PromptChatHistory, Enabled: true}
+	disabled := PromptBlock{Identifier: PromptChatHistory}
+	main := PromptBlock{Identifier: PromptMain, Enabled: true}
+	depth := func(d int) *int { return &d }
+	tests := []struct {
+		name    string
+		order   []PromptBlock
+		wantErr bool
+	}{
+		{"default", DefaultPromptOrder(), false},
+		{"only history", []PromptBlock{history}, false},
+		{"disabled duplicate", []PromptBlock{main, history, disabled}, false},
+		{"empty", nil, true},
+		{"no history", []PromptBlock{main}, true},
+		{"history disabled", []PromptBlock{main, disabled}, true},
+		{"two histories", []PromptBlock{history, main, history}, true},
+		{"injection depth", []PromptBlock{main, history, {Identifier: "note", Enabled: true, InjectionDepth: depth(0)}}, false},
+		{"negative injection depth", []PromptBlock{main, history, {Identifier: "note", Enabled: true, InjectionDepth: depth(-1)}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePromptOrder(tt.order); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePromptOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// formatMessages 将消息格式化为 "角色:内容"，便于比较
+func formatMessages(messages []messageType) []string {
+	formatted := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		formatted = append(formatted, fmt.Sprintf("%s:%s", msg.Role.ToOpenAIRole(), msg.Content))
+	}
+	return formatted
+}
+
+func TestBuildPrompt(t *testing.T) {
+	depth := func(d int) *int { return &d }
+	block := func(identifier string) PromptBlock {
+		return PromptBlock{Identifier: identifier, Enabled: true}
+	}
+	chat := []string{"system:[Start a new Chat]", "user:one", "assistant:two", "user:three"}
+	tests := []struct {
+		name    string
+		order   []PromptBlock
+		want    []string
+		wantErr bool
+	}{
+		{
+			"default order", DefaultPromptOrder(),
+			append([]string{"system:Main", "system:Desc", "system:Pers", "system:Scen"}, chat...), false,
+		},
+		{
+			"custom order", []PromptBlock{block(PromptScenario), block(PromptChatHistory), block(PromptDescription)},
+			append(append([]string{"system:Scen"}, chat...), "system:Desc"), false,
+		},
+		{
+			"disabled blocks", []PromptBlock{block(PromptMain), {Identifier: PromptDescription}, block(PromptChatHistory), {Identifier: "custom", Content: "Off"}},
+			append([]string{"system:Main"}, chat...), false,
+		},
+		{
+			"role override", []PromptBlock{{Identifier: PromptDescription, Enabled: true, Role: "user"}, block(PromptChatHistory)},
+			append([]string{"user:Desc"}, chat...), false,
+		},
+		{
+			"invalid role", []PromptBlock{{Identifier: PromptDescription, Enabled: true, Role: "narrator"}, block(PromptChatHistory)},
+			nil, true,
+		},
+		{
+			"custom block", []PromptBlock{block(PromptChatHistory), {Identifier: "custom", Enabled: true, Role: "assistant", Content: "I am {{char}}."}},
+			append(slices.Clone(chat), "assistant:I am Alice."), false,
+		},
+		{
+			"main and post history content", []PromptBlock{{Identifier: PromptMain, Enabled: true, Content: "Block main"}, block(PromptChatHistory), {Identifier: PromptPostHistory, Enabled: true, Content: "Block post"}},
+			append(append([]string{"system:Block main"}, chat...), "system:Block post"), false,
+		},
+		{
+			"injection depths", []PromptBlock{
+				{Identifier: "d0", Enabled: true, Content: "Depth 0", InjectionDepth: depth(0)},
+				block(PromptChatHistory),
+				{Identifier: "d1", Enabled: true, Content: "Depth 1", InjectionDepth: depth(1)},
+				{Identifier: "deep", Enabled: true, Content: "Deep", InjectionDepth: depth(10)},
+				{Identifier: PromptAuthorsNote, Enabled: true, Content: "Note", InjectionDepth: depth(2)},
+			},
+			[]string{"system:[Start a new Chat]", "system:Deep", "user:one", "system:Note", "assistant:two", "system:Depth 1", "user:three", "system:Depth 0"}, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{Description: "Desc", Personality: "Pers", Scenario: "Scen"})
+			c.MainPrompt = "Main"
+			c.PromptOrder = tt.order
+			messages, err := c.buildPrompt(testChat("one", "two", "three"), nil, nil, &ApplyReport{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildPrompt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := formatMessages(messages); err == nil && !slices.Equal(got, tt.want) {
+				t.Errorf("buildPrompt() = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package st
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// timedEffect 记录条目激活后的定时效果，均以聊天长度 (消息条数) 计
+type timedEffect struct {
+	Activated   int // 激活时的聊天长度
+	StickyEnd   int // 在此之前保持激活
+	CooldownEnd int // sticky 结束后，在此之前不会激活
+}
+
+func (e timedEffect) stickyAt(chatLength int) bool {
+	return e.Activated <= chatLength && chatLength < e.StickyEnd
+}
+
+func (e timedEffect) coolingDownAt(chatLength int) bool {
+	return max(e.StickyEnd, e.Activated+1) <= chatLength && chatLength < e.CooldownEnd
+}
+
+// expiredAt 返回效果是否已结束，或聊天已回退到激活之前
+func (e timedEffect) expiredAt(chatLength int) bool {
+	return chatLength < e.Activated || chatLength >= max(e.StickyEnd, e.CooldownEnd)
+}
+
+// WorldInfoState 保存一个聊天中 World Info 定时效果 (sticky, cooldown) 的状态，nil 表示不保存状态
+type WorldInfoState struct {
+	mu       sync.Mutex
+	effects  map[string]timedEffect
+	lastUsed time.Time
+}
+
+// Clone 返回状态的副本，用于不影响聊天状态的预览
+func (s *WorldInfoState) Clone() *WorldInfoState {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &WorldInfoState{
+		effects:  maps.Clone(s.effects),
+		lastUsed: s.lastUsed,
+	}
+}
+
+// isSticky 返回条目是否处于 sticky 状态
+func (s *WorldInfoState) isSticky(key string, chatLength int) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.effects[key].stickyAt(chatLength)
+}
+
+// isCoolingDown 返回条目是否处于 cooldown 状态
+func (s *WorldInfoState) isCoolingDown(key string, chatLength int) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.effects[key].coolingDownAt(chatLength)
+}
+
+// activate 记录条目在当前聊天长度被激活。
+// sticky 条目在之后的 sticky 条消息内保持激活，cooldown 条目在激活 (或 sticky) 结束后的 cooldown 条消息内不会激活。
+func (s *WorldInfoState) activate(key string, chatLength int, sticky int, cooldown int) {
+	if s == nil || (sticky <= 0 && cooldown <= 0) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.effects[key].stickyAt(chatLength) {
+		return
+	}
+	effect := timedEffect{Activated: chatLength, StickyEnd: chatLength + max(sticky, 0)}
+	effect.CooldownEnd = max(effect.StickyEnd, chatLength+1) + max(cooldown, 0)
+	s.effects[key] = effect
+}
+
+// expire 清除已结束的效果
+func (s *WorldInfoState) expire(chatLength int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.DeleteFunc(s.effects, func(_ string, e timedEffect) bool {
+		return e.expiredAt(chatLength)
+	})
+}
+
+// worldInfoSweepInterval 是清除过期状态的间隔，以 Get 的调用次数计
+const worldInfoSweepInterval = 100
+
+// WorldInfoStore 按聊天ID保存 World Info 状态，超过 ttl 未使用的状态会被定期清除
+type WorldInfoStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	states map[string]*WorldInfoState
+	calls  int // 距上次清除的 Get 调用次数
+}
+
+// NewWorldInfoStore 返回一个新的内存状态存储
+func NewWorldInfoStore(ttl time.Duration) *WorldInfoStore {
+	return &WorldInfoStore{
+		ttl:    ttl,
+		states: make(map[string]*WorldInfoState),
+	}
+}
+
+// Get 返回聊天的状态，不存在时创建。
+// 返回的状态会在应用角色卡时被修改，需要在请求成功后才保存时，应修改其 Clone 并调用 Commit。
+func (s *WorldInfoStore) Get(chatID string) *WorldInfoState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.calls++; s.calls >= worldInfoSweepInterval {
+		s.calls = 0
+		s.sweep(now)
+	}
+	state, ok := s.states[chatID]
+	if !ok {
+		state = &WorldInfoState{effects: make(map[string]timedEffect)}
+		s.states[chatID] = state
+	}
+	state.mu.Lock()
+	state.lastUsed = now
+	state.mu.Unlock()
+	return state
+}
+
+// Commit 以 state 替换聊天的状态，state 为 nil 时不做修改
+func (s *WorldInfoStore) Commit(chatID string, state *WorldInfoState) {
+	if state == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.mu.Lock()
+	state.lastUsed = time.Now()
+	state.mu.Unlock()
+	s.states[chatID] = state
+}
+
+// sweep 清除超过 ttl 未使用的状态，调用时须持有 s.mu
+func (s *WorldInfoStore) sweep(now time.Time) {
+	for id, state := range s.states {
+		state.mu.Lock()
+		expired := now.Sub(state.lastUsed) > s.ttl
+		state.mu.Unlock()
+		if expired {
+			delete(s.states, id)
+		}
+	}
+}
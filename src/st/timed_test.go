@@ -0,0 +1,123 @@
+package st
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+)
+
+func TestTimedEffect(t *testing.T) {
+	// Activated at chat length 4 with sticky 2 and cooldown 3
+	state := &WorldInfoState{effects: make(map[string]timedEffect)}
+	state.activate("key", 4, 2, 3)
+	tests := []struct {
+		chatLength  int
+		sticky      bool
+		coolingDown bool
+	}{
+		{3, false, false},
+		{4, true, false},
+		{5, true, false},
+		{6, false, true},
+		{8, false, true},
+		{9, false, false},
+	}
+	for _, tt := range tests {
+		if got := state.isSticky("key", tt.chatLength); got != tt.sticky {
+			t.Errorf("isSticky(%d) = %v, want %v", tt.chatLength, got, tt.sticky)
+		}
+		if got := state.isCoolingDown("key", tt.chatLength); got != tt.coolingDown {
+			t.Errorf("isCoolingDown(%d) = %v, want %v", tt.chatLength, got, tt.coolingDown)
+		}
+	}
+
+	state.expire(9)
+	if _, ok := state.effects["key"]; ok {
+		t.Error("expire(9) kept the finished effect")
+	}
+}
+
+func TestTimedWorldInfo(t *testing.T) {
+	sticky := testEntry("sticky", "dragon")
+	sticky.Extensions.Sticky = 2
+	cooldown := testEntry("cooldown", "dragon")
+	cooldown.Extensions.Cooldown = 2
+	delayed := testEntry("delayed", "dragon")
+	delayed.Extensions.Delay = 3
+
+	// Each step appends a user message, so the chat length is the step number
+	steps := []struct {
+		text string
+		want []string
+	}{
+		{"a dragon", []string{"cooldown", "sticky"}},
+		{"nothing", []string{"sticky"}},
+		{"a dragon", []string{"delayed", "sticky"}},
+		{"a dragon", []string{"cooldown", "delayed", "sticky"}},
+	}
+	c := newTestCard(t, ccv3.CharacterCardData{}, sticky, cooldown, delayed)
+	state := NewWorldInfoStore(time.Hour).Get("chat")
+	history := make([]messageType, 0)
+	for i, step := range steps {
+		history = append(history, messageType{Role: user, Content: step.text})
+		got := activatedNames(t, c, history, ApplyOptions{State: state})
+		if !slices.Equal(got, step.want) {
+			t.Errorf("step %d: activated = %v, want %v", i+1, got, step.want)
+		}
+	}
+}
+
+func TestWorldInfoStateClone(t *testing.T) {
+	entry := testEntry("sticky", "dragon")
+	entry.Extensions.Sticky = 5
+	c := newTestCard(t, ccv3.CharacterCardData{}, entry)
+	state := NewWorldInfoStore(time.Hour).Get("chat")
+
+	activatedNames(t, c, testChat("a dragon"), ApplyOptions{State: state.Clone()})
+	if got := activatedNames(t, c, testChat("nothing", "ok", "nothing"), ApplyOptions{State: state}); len(got) != 0 {
+		t.Errorf("preview changed the chat state, activated = %v", got)
+	}
+}
+
+func TestWorldInfoStoreCommit(t *testing.T) {
+	entry := testEntry("cooldown", "dragon")
+	entry.Extensions.Cooldown = 3
+	c := newTestCard(t, ccv3.CharacterCardData{}, entry)
+	store := NewWorldInfoStore(time.Hour)
+
+	// A failed request applies to a clone that is never committed
+	activatedNames(t, c, testChat("a dragon"), ApplyOptions{State: store.Get("chat").Clone()})
+	retry := store.Get("chat").Clone()
+	if got := activatedNames(t, c, testChat("a dragon"), ApplyOptions{State: retry}); !slices.Equal(got, []string{"cooldown"}) {
+		t.Fatalf("retry activated = %v, want [cooldown]", got)
+	}
+
+	store.Commit("chat", retry)
+	if got := activatedNames(t, c, testChat("a dragon", "ok", "a dragon"), ApplyOptions{State: store.Get("chat").Clone()}); len(got) != 0 {
+		t.Errorf("activated after commit = %v, want the entry on cooldown", got)
+	}
+	store.Commit("chat", nil)
+	if len(store.Get("chat").effects) == 0 {
+		t.Error("Commit(nil) cleared the chat state")
+	}
+}
+
+func TestWorldInfoStoreSweep(t *testing.T) {
+	store := NewWorldInfoStore(time.Minute)
+	store.Get("old").lastUsed = time.Now().Add(-time.Hour)
+	for range worldInfoSweepInterval - 2 {
+		store.Get("new")
+	}
+	if _, ok := store.states["old"]; !ok {
+		t.Fatal("state removed before the sweep interval")
+	}
+	store.Get("new")
+	if _, ok := store.states["old"]; ok {
+		t.Error("expired state kept after the sweep")
+	}
+	if _, ok := store.states["new"]; !ok {
+		t.Error("recently used state removed")
+	}
+}
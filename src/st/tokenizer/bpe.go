@@ -0,0 +1,228 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// 支持的 BPE 编码，词表文件为 tiktoken 格式 (每行为 base64 编码的 token 与其序号)
+const (
+	EncodingCL100K = "cl100k_base"
+	EncodingO200K  = "o200k_base"
+)
+
+// 预分词规则。原始规则中的 `\s+(?!\S)` 使用了 Go 不支持的零宽断言，
+// 改为捕获最后一个 `\s+` 分支，并在 split 中让出最后一个空白字符。
+var bpePatterns = map[string]*regexp.Regexp{
+	EncodingCL100K: regexp.MustCompile(`^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|(\s+))`),
+	EncodingO200K: regexp.MustCompile(`^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|(\s+))`),
+}
+
+// BPE 是使用 tiktoken 词表的字节级 BPE 分词器
+type BPE struct {
+	name    string
+	pattern *regexp.Regexp
+	ranks   map[string]int
+	tokens  map[int]string
+}
+
+// NewBPE 使用给定编码的预分词规则与词表创建分词器
+func NewBPE(encoding string, ranks map[string]int) (*BPE, error) {
+	pattern, ok := bpePatterns[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("vocabulary is missing byte 0x%02x", b)
+		}
+	}
+	tokens := make(map[int]string, len(ranks))
+	for token, rank := range ranks {
+		tokens[rank] = token
+	}
+	return &BPE{
+		name:    encoding,
+		pattern: pattern,
+		ranks:   ranks,
+		tokens:  tokens,
+	}, nil
+}
+
+// LoadBPE 从 tiktoken 格式的词表文件创建分词器
+func LoadBPE(encoding string, filePath string) (*BPE, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vocabulary: %w", err)
+	}
+	ranks, err := ParseTiktoken(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewBPE(encoding, ranks)
+}
+
+// ParseTiktoken 解析 tiktoken 格式的词表
+func ParseTiktoken(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid vocabulary at line %d", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid token at line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank at line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vocabulary: %w", err)
+	}
+	return ranks, nil
+}
+
+func (t *BPE) Name() string {
+	return t.name
+}
+
+func (t *BPE) Count(text string) int {
+	return len(t.Encode(text))
+}
+
+func (t *BPE) Encode(text string) []int {
+	tokens := make([]int, 0, len(text)/3)
+	for _, piece := range t.split(text) {
+		tokens = t.encodePiece(piece, tokens)
+	}
+	return tokens
+}
+
+func (t *BPE) Decode(tokens []int) string {
+	buf := bytes.Buffer{}
+	for _, token := range tokens {
+		buf.WriteString(t.tokens[token])
+	}
+	return buf.String()
+}
+
+// split 按预分词规则切分文本
+func (t *BPE) split(text string) []string {
+	pieces := make([]string, 0)
+	for len(text) > 0 {
+		end := 0
+		if loc := t.pattern.FindStringSubmatchIndex(text); loc != nil && loc[1] > 0 {
+			end = loc[1]
+			// Emulate `\s+(?!\S)`: leave the last whitespace to prefix the next piece
+			if loc[2] >= 0 && end < len(text) {
+				_, size := utf8.DecodeLastRuneInString(text[:end])
+				if end-size > 0 {
+					end -= size
+				}
+			}
+		} else {
+			_, end = utf8.DecodeRuneInString(text)
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// encodePiece 对单个预分词片段执行 BPE 合并。
+// 片段以链表保存，候选合并放入按 (rank, 位置) 排序的堆中，长片段的合并为 O(n log n)。
+func (t *BPE) encodePiece(piece string, tokens []int) []int {
+	if rank, ok := t.ranks[piece]; ok {
+		return append(tokens, rank)
+	}
+	n := len(piece)
+	parts := make([]bpePart, n)
+	for i := range parts {
+		parts[i] = bpePart{end: i + 1, prev: i - 1, next: i + 1}
+	}
+	pairs := &bpePairHeap{}
+	push := func(left int) {
+		if left < 0 || parts[left].next >= n {
+			return
+		}
+		right := parts[left].next
+		if rank, ok := t.ranks[piece[left:parts[right].end]]; ok {
+			heap.Push(pairs, bpePair{rank: rank, left: left, right: right, leftEnd: parts[left].end, rightEnd: parts[right].end})
+		}
+	}
+	for i := 0; i < n-1; i++ {
+		push(i)
+	}
+	for pairs.Len() > 0 {
+		pair := heap.Pop(pairs).(bpePair)
+		left, right := &parts[pair.left], &parts[pair.right]
+		// 跳过在入堆后已被其他合并改变的候选
+		if left.merged || right.merged || left.next != pair.right || left.end != pair.leftEnd || right.end != pair.rightEnd {
+			continue
+		}
+		left.end = right.end
+		left.next = right.next
+		right.merged = true
+		if right.next < n {
+			parts[right.next].prev = pair.left
+		}
+		push(left.prev)
+		push(pair.left)
+	}
+	for i := 0; i < n; i = parts[i].next {
+		tokens = append(tokens, t.ranks[piece[i:parts[i].end]])
+	}
+	return tokens
+}
+
+// bpePart 是以其下标为起始字节的片段，merged 表示已并入左侧片段
+type bpePart struct {
+	end        int
+	prev, next int
+	merged     bool
+}
+
+// bpePair 是相邻两个片段的候选合并，记录入堆时两侧的结束位置
+type bpePair struct {
+	rank              int
+	left, right       int
+	leftEnd, rightEnd int
+}
+
+// bpePairHeap 按 rank 排序，rank 相同时优先合并靠左的片段
+type bpePairHeap []bpePair
+
+func (h bpePairHeap) Len() int { return len(h) }
+func (h bpePairHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].left < h[j].left
+}
+func (h bpePairHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *bpePairHeap) Push(x any)   { *h = append(*h, x.(bpePair)) }
+func (h *bpePairHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,244 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testRanks 返回包含全部单字节与少量合并规则的词表
+func testRanks() map[string]int {
+	ranks := make(map[string]int)
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, token := range []string{"he", "ll", "hell", "hello", " w", "or", " wor", "ld", " world"} {
+		ranks[token] = 256 + i
+	}
+	return ranks
+}
+
+func TestBPEEncode(t *testing.T) {
+	bpe, err := NewBPE(EncodingCL100K, testRanks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"", []int{}},
+		{"hello", []int{259}},
+		{"hellohe", []int{259, 256}},
+		{"hello world", []int{259, 264}},
+		{"hello  world", []int{259, ' ', 264}},
+		{"hi", []int{'h', 'i'}},
+		{"你", []int{0xe4, 0xbd, 0xa0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := bpe.Encode(tt.text)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+			if n := bpe.Count(tt.text); n != len(tt.want) {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, n, len(tt.want))
+			}
+			if decoded := bpe.Decode(got); decoded != tt.text {
+				t.Errorf("Decode(Encode(%q)) = %q", tt.text, decoded)
+			}
+		})
+	}
+}
+
+// naiveEncodePiece 是逐轮扫描最低 rank 的参考实现
+func naiveEncodePiece(ranks map[string]int, piece string) []int {
+	parts := make([]string, 0, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts = append(parts, piece[i:i+1])
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := ranks[parts[i]+parts[i+1]]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = slices.Delete(parts, best+1, best+2)
+	}
+	tokens := make([]int, 0, len(parts))
+	for _, part := range parts {
+		tokens = append(tokens, ranks[part])
+	}
+	return tokens
+}
+
+func TestBPEEncodePieceMatchesNaive(t *testing.T) {
+	ranks := testRanks()
+	for i, token := range []string{"aa", "ab", "ba", "aaa", "abab", "bb", "aab", "baa"} {
+		ranks[token] = 300 + i
+	}
+	bpe, err := NewBPE(EncodingCL100K, ranks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 500 {
+		b := make([]byte, 1+rng.IntN(24))
+		for i := range b {
+			b[i] = "ab"[rng.IntN(2)]
+		}
+		piece := string(b)
+		got := bpe.encodePiece(piece, nil)
+		if want := naiveEncodePiece(ranks, piece); !slices.Equal(got, want) {
+			t.Fatalf("encodePiece(%q) = %v, want %v", piece, got, want)
+		}
+	}
+}
+
+func TestBPEEncodeLongPiece(t *testing.T) {
+	ranks := testRanks()
+	ranks["aa"] = 300
+	bpe, err := NewBPE(EncodingCL100K, ranks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("a", 1<<16+1)
+	tokens := bpe.Encode(text)
+	if len(tokens) != 1<<15+1 {
+		t.Fatalf("len(Encode) = %d, want %d", len(tokens), 1<<15+1)
+	}
+	if tokens[0] != 300 || tokens[len(tokens)-1] != 'a' {
+		t.Errorf("Encode() starts with %d and ends with %d", tokens[0], tokens[len(tokens)-1])
+	}
+}
+
+func BenchmarkBPEEncodeLongPiece(b *testing.B) {
+	ranks := testRanks()
+	ranks["aa"] = 300
+	bpe, err := NewBPE(EncodingCL100K, ranks)
+	if err != nil {
+		b.Fatal(err)
+	}
+	text := strings.Repeat("a", 1<<16)
+	for b.Loop() {
+		bpe.Encode(text)
+	}
+}
+
+func TestBPESplit(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []string
+	}{
+		{EncodingCL100K, "hello world", []string{"hello", " world"}},
+		{EncodingCL100K, "hello   world", []string{"hello", "  ", " world"}},
+		{EncodingCL100K, "it's 12345", []string{"it", "'s", " ", "123", "45"}},
+		{EncodingCL100K, "trailing  ", []string{"trailing", "  "}},
+		{EncodingCL100K, "a\n\nb", []string{"a", "\n\n", "b"}},
+		{EncodingO200K, "HelloWorld", []string{"Hello", "World"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding+"/"+tt.text, func(t *testing.T) {
+			bpe, err := NewBPE(tt.encoding, testRanks())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bpe.split(tt.text); !slices.Equal(got, tt.want) {
+				t.Errorf("split(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBPE(t *testing.T) {
+	missing := testRanks()
+	delete(missing, "\x00")
+	tests := []struct {
+		name     string
+		encoding string
+		ranks    map[string]int
+		wantErr  bool
+	}{
+		{"cl100k", EncodingCL100K, testRanks(), false},
+		{"o200k", EncodingO200K, testRanks(), false},
+		{"unsupported encoding", "p50k_base", testRanks(), true},
+		{"missing byte", EncodingCL100K, missing, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBPE(tt.encoding, tt.ranks)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewBPE() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTiktoken(t *testing.T) {
+	line := func(token string, rank int) string {
+		return fmt.Sprintf("%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"valid", line("a", 0) + "\n" + line("ab", 1), map[string]int{"a": 0, "ab": 1}, false},
+		{"missing rank", "YQ==\n", nil, true},
+		{"invalid base64", "!!! 0\n", nil, true},
+		{"invalid rank", "YQ== x\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTiktoken([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTiktoken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseTiktoken() = %v, want %v", got, tt.want)
+			}
+			for token, rank := range tt.want {
+				if got[token] != rank {
+					t.Errorf("rank of %q = %d, want %d", token, got[token], rank)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimatorCount(t *testing.T) {
+	tests := []struct {
+		text          string
+		charsPerToken float64
+		want          int
+	}{
+		{"", 4, 0},
+		{"abcd", 4, 1},
+		{"abcde", 4, 2},
+		{"你好", 4, 2},
+		{"hi 你好", 4, 3},
+		{"abcd", 0, 1},
+		{strings.Repeat("a", 10), 2, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			e := &Estimator{CharsPerToken: tt.charsPerToken}
+			if got := e.Count(tt.text); got != tt.want {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+// EstimatorName 是字符比例估算器的名称
+const EstimatorName = "estimate"
+
+// Estimator 按字符数估算 token 数，ASCII 字符按 CharsPerToken 个字符计一个 token，其余字符每个计一个 token
+type Estimator struct {
+	CharsPerToken float64
+}
+
+// NewEstimator 返回使用默认比例的估算器
+func NewEstimator() *Estimator {
+	return &Estimator{CharsPerToken: 4}
+}
+
+func (e *Estimator) Name() string {
+	return EstimatorName
+}
+
+func (e *Estimator) Count(text string) int {
+	ascii, wide := 0, 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			wide++
+		}
+		i += size
+	}
+	ratio := e.CharsPerToken
+	if ratio <= 0 {
+		ratio = 4
+	}
+	return int(math.Ceil(float64(ascii)/ratio)) + wide
+}
@@ -0,0 +1,19 @@
+// Package tokenizer 提供离线的 token 计数，用于上下文窗口与设定集的 token 预算
+package tokenizer
+
+// Tokenizer 计算文本的 token 数
+type Tokenizer interface {
+	// Name 返回分词器名称
+	Name() string
+	// Count 返回文本的 token 数
+	Count(text string) int
+}
+
+// Encoder 是可以输出 token ID 的分词器
+type Encoder interface {
+	Tokenizer
+	// Encode 将文本编码为 token ID
+	Encode(text string) []int
+	// Decode 将 token ID 解码为文本
+	Decode(tokens []int) string
+}
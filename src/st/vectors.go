@@ -0,0 +1,104 @@
+package st
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwindy/xitu/st/embedding"
+	"github.com/rs/zerolog/log"
+)
+
+// VectorSearch 定义向量化条目的相似度激活
+type VectorSearch struct {
+	Embedder   embedding.Embedder
+	Index      *embedding.Index // 条目内容的向量缓存 (可选, 默认每次请求重新计算)
+	Threshold  float32          // 余弦相似度阈值
+	MaxEntries int              // 最多激活的条目数，0 为不限制
+	QueryDepth int              // 作为检索内容的最近消息条数 (可选, 默认 2)
+	Timeout    time.Duration    // 单次向量化的超时 (可选, 默认 10 秒)
+}
+
+// vectorKey 返回条目内容在向量索引中的键，内容变化后键随之变化
+func vectorKey(entry lorebookEntryType) string {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(entry.Content))
+	return entry.stateKey() + "#" + strconv.FormatUint(f.Sum64(), 16)
+}
+
+// activate 返回与最近聊天记录相似的向量化条目，键为 stateKey
+func (v *VectorSearch) activate(ctx context.Context, messages []messageType, entries lorebookEntriesType) map[string]bool {
+	ids := make([]string, 0)
+	keys := make(map[string]string)
+	contents := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Vectorized && entry.Content != "" {
+			id := vectorKey(entry)
+			if _, ok := keys[id]; !ok {
+				ids = append(ids, id)
+			}
+			keys[id] = entry.stateKey()
+			contents[id] = entry.Content
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if v == nil || v.Embedder == nil {
+		log.Debug().Int("count", len(ids)).Msg("Vector search is not configured, vectorized entries skipped")
+		return nil
+	}
+
+	depth := v.QueryDepth
+	if depth <= 0 {
+		depth = 2
+	}
+	query := make([]string, 0, depth)
+	for i := len(messages) - 1; i >= 0 && len(query) < depth; i-- {
+		if messages[i].Content != "" {
+			query = append(query, messages[i].Content)
+		}
+	}
+	if len(query) == 0 {
+		return nil
+	}
+
+	index := v.Index
+	if index == nil {
+		index = embedding.NewIndex(0)
+	}
+	texts := []string{strings.Join(query, "\n")}
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if _, ok := index.Get(id); !ok {
+			missing = append(missing, id)
+			texts = append(texts, contents[id])
+		}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	vectors, err := v.Embedder.Embed(ctx, texts)
+	if err != nil || len(vectors) != len(texts) {
+		log.Warn().Err(err).Str("embedder", v.Embedder.Name()).Msg("Failed to embed lorebook entries, vectorized entries skipped")
+		return nil
+	}
+	for i, id := range missing {
+		index.Add(id, vectors[i+1])
+	}
+
+	activated := make(map[string]bool)
+	for _, match := range index.Search(vectors[0], ids, v.Threshold, v.MaxEntries) {
+		activated[keys[match.ID]] = true
+		log.Debug().Str("key", keys[match.ID]).Float32("score", match.Score).Msg("Vectorized lorebook entry matched")
+	}
+	return activated
+}
@@ -0,0 +1,102 @@
+package st
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/cloudwindy/xitu/st/embedding"
+)
+
+// countingEmbedder 记录每次向量化的文本条数，err 不为空时返回错误
+type countingEmbedder struct {
+	embedding.Embedder
+	calls []int
+	err   error
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls = append(e.calls, len(texts))
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.Embedder.Embed(ctx, texts)
+}
+
+// blockingEmbedder 直到上下文取消才返回
+type blockingEmbedder struct{}
+
+func (blockingEmbedder) Name() string { return "blocking" }
+func (blockingEmbedder) Embed(ctx context.Context, _ []string) ([][]float32, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func vectorEntry(name string, content string, keys ...string) ccv3.LorebookEntry {
+	entry := testEntry(name, keys...)
+	entry.Content = content
+	entry.Extensions.Vectorized = true
+	return entry
+}
+
+func TestVectorSearch(t *testing.T) {
+	cave := vectorEntry("cave", "The dragon sleeps in a cave under the mountain.")
+	lair := vectorEntry("lair", "A dragon guards its lair in the mountain.")
+	taxes := vectorEntry("taxes", "Merchants pay taxes at the city bank.")
+	keyed := vectorEntry("keyed", "Unrelated text about weather.", "dragon")
+	chat := testChat("Where does the dragon sleep in the mountain?")
+
+	tests := []struct {
+		name    string
+		vectors *VectorSearch
+		entries []ccv3.LorebookEntry
+		chat    []messageType
+		want    []string
+	}{
+		{"disabled", nil, []ccv3.LorebookEntry{cave, keyed}, chat, []string{"keyed"}},
+		{"similar entries", &VectorSearch{Embedder: embedding.NewHashing(0), Threshold: 0.3}, []ccv3.LorebookEntry{cave, lair, taxes}, chat, []string{"cave", "lair"}},
+		{"max entries", &VectorSearch{Embedder: embedding.NewHashing(0), Threshold: 0.3, MaxEntries: 1}, []ccv3.LorebookEntry{cave, lair, taxes}, chat, []string{"cave"}},
+		{"keys still match", &VectorSearch{Embedder: embedding.NewHashing(0), Threshold: 0.3}, []ccv3.LorebookEntry{keyed}, chat, []string{"keyed"}},
+		{"query depth", &VectorSearch{Embedder: embedding.NewHashing(0), Threshold: 0.3, QueryDepth: 1}, []ccv3.LorebookEntry{cave}, testChat("Where does the dragon sleep in the mountain?", "ok", "Sounds good to me."), []string{}},
+		{"embedder error", &VectorSearch{Embedder: &countingEmbedder{Embedder: embedding.NewHashing(0), err: errors.New("down")}, Threshold: 0.3}, []ccv3.LorebookEntry{cave, keyed}, chat, []string{"keyed"}},
+		{"timeout", &VectorSearch{Embedder: blockingEmbedder{}, Threshold: 0.3, Timeout: 10 * time.Millisecond}, []ccv3.LorebookEntry{cave, keyed}, chat, []string{"keyed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{}, tt.entries...)
+			got := activatedNames(t, c, tt.chat, ApplyOptions{Vectors: tt.vectors})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVectorSearchIndex(t *testing.T) {
+	embedder := &countingEmbedder{Embedder: embedding.NewHashing(0)}
+	vectors := &VectorSearch{Embedder: embedder, Index: embedding.NewIndex(10), Threshold: 0.3}
+	cave := vectorEntry("cave", "The dragon sleeps in a cave under the mountain.")
+	c := newTestCard(t, ccv3.CharacterCardData{}, cave, vectorEntry("taxes", "Merchants pay taxes at the city bank."))
+	chat := testChat("Where does the dragon sleep in the mountain?")
+
+	for range 2 {
+		if got := activatedNames(t, c, chat, ApplyOptions{Vectors: vectors}); !slices.Equal(got, []string{"cave"}) {
+			t.Fatalf("activated = %v, want [cave]", got)
+		}
+	}
+	// Entries are embedded once, later requests only embed the query
+	if !slices.Equal(embedder.calls, []int{3, 1}) {
+		t.Errorf("embedded text counts = %v, want [3 1]", embedder.calls)
+	}
+
+	// Changing the content embeds the entry again
+	cave.Content = "The dragon now sleeps on a mountain peak."
+	c = newTestCard(t, ccv3.CharacterCardData{}, cave)
+	activatedNames(t, c, chat, ApplyOptions{Vectors: vectors})
+	if got := embedder.calls[len(embedder.calls)-1]; got != 2 {
+		t.Errorf("embedded %d texts after a content change, want 2", got)
+	}
+}
@@ -5,20 +5,38 @@ import (
 	"fmt"
 	"math/rand"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/cloudwindy/xitu/st/tokenizer"
 	"github.com/rs/zerolog/log"
 )
 
-func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error) {
-	if len(c.lorebook) == 0 {
+func (c *cardType) checkWorldInfo(messages []messageType, entries lorebookEntriesType, opts ApplyOptions) (*worldInfoType, error) {
+	if len(entries) == 0 {
 		log.Debug().Msg("No lorebook found")
 		return nil, nil
 	}
-	lorebook := c.lorebook.Copy()
+	lorebook := entries.Copy()
 	lorebook.Sort()
+	generationType := opts.GenerationType
+	if generationType == "" {
+		generationType = ccv3.TriggerNormal
+	}
+	lorebook = lorebook.Trigger(generationType)
+	lorebook.ExpandMacros(c.macroReplacer())
+	budget := c.newWorldInfoBudget(opts.Budget)
+	chatLength := len(messages)
+	timed := opts.State
+	timed.expire(chatLength)
+	vectorized := opts.Vectors.activate(opts.Context, messages, lorebook)
+
+	// Recursion starts at level 0, where entries delayed until recursion are not eligible yet
+	levels := lorebook.DelayLevels()
+	level := 0
 
 	count := 0
 	state := scanStateInitial
@@ -36,6 +54,27 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 				continue
 			}
 
+			// Activated if sticky
+			if timed.isSticky(entry.stateKey(), chatLength) {
+				lorebook[i].activated = true
+				entry.sticky = true
+				newEntries.Push(entry)
+				log.Debug().Str("name", entry.Name).Msg("Lorebook entry is sticky")
+				continue
+			}
+
+			// Not Activated if on cooldown
+			if timed.isCoolingDown(entry.stateKey(), chatLength) {
+				log.Debug().Str("name", entry.Name).Msg("Lorebook entry is on cooldown")
+				continue
+			}
+
+			// Not Activated if delayed until the chat has enough messages
+			if entry.Delay > 0 && chatLength < entry.Delay {
+				log.Debug().Str("name", entry.Name).Int("delay", entry.Delay).Msg("Lorebook entry delayed")
+				continue
+			}
+
 			// Not Activated if uses probability and roll fails or previously failed
 			if entry.UseProbability {
 				if entry.rollFailed {
@@ -59,9 +98,9 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 				continue
 			}
 
-			// Not Activated if delayed until recursion and not in recursion state
-			if entry.DelayUntilRecursion != nil && entry.DelayUntilRecursion != 0 && entry.DelayUntilRecursion != false && state != scanStateRecursion {
-				log.Debug().Str("name", entry.Name).Msg("Lorebook entry delayed until recursion")
+			// Not Activated if delayed until recursion and not in recursion state or a lower recursion level
+			if delay := entry.delayLevel(); delay > 0 && (state != scanStateRecursion || delay > level) {
+				log.Debug().Str("name", entry.Name).Int("delay_level", delay).Msg("Lorebook entry delayed until recursion")
 				continue
 			}
 
@@ -71,6 +110,20 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 				continue
 			}
 
+			// Not Activated if its lorebook disables recursive scanning and in recursion state
+			if !entry.recursiveScanning() && state == scanStateRecursion {
+				log.Debug().Str("name", entry.Name).Msg("Lorebook entry excluded in recursion by lorebook")
+				continue
+			}
+
+			// Activated if vectorized and similar to the chat, otherwise still matched by keys
+			if entry.Vectorized && vectorized[entry.stateKey()] {
+				lorebook[i].activated = true
+				newEntries.Push(entry)
+				log.Debug().Str("name", entry.Name).Msg("Lorebook entry matches by similarity")
+				continue
+			}
+
 			// Not Activated if no keys to match against
 			if len(entry.Keys) == 0 {
 				log.Debug().Str("name", entry.Name).Msg("Lorebook entry has no keys to match against")
@@ -83,27 +136,43 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 				continue
 			}
 
-			// Activated if matches any key
-			for _, key := range entry.Keys {
-				if buf.Match(key, entry) {
-					lorebook[i].activated = true
-					newEntries.Push(entry)
-					log.Debug().Str("name", entry.Name).Str("key", key).Msg("Lorebook entry matches key")
-					break
+			// Activated if matches any key and the secondary keys satisfy the selective logic
+			if key, ok := c.matchEntry(&buf, entry); ok {
+				lorebook[i].activated = true
+				if entry.UseGroupScoring {
+					entry.score = c.scoreEntry(&buf, entry)
 				}
+				newEntries.Push(entry)
+				log.Debug().Str("name", entry.Name).Str("key", key).Msg("Lorebook entry matches key")
 			}
 		}
 
+		newEntries = filterInclusionGroups(newEntries, activated)
+		newEntries = budget.Admit(newEntries, opts.Report)
 		if len(newEntries) > 0 {
 			activated.Push(newEntries...)
 		}
 		remaining := lorebook.Len() - activated.Len()
-		if len(newEntries.Recursive()) > 0 && remaining > 0 {
+		if budget.overflowed {
+			log.Debug().Int("used", budget.used).Int("total", budget.total).Msg("World Info budget exhausted")
+		} else if c.WorldInfoMaxRecursionSteps > 0 && count > c.WorldInfoMaxRecursionSteps {
+			log.Debug().Int("steps", c.WorldInfoMaxRecursionSteps).Msg("World Info max recursion steps reached")
+		} else if len(newEntries.Recursive()) > 0 && remaining > 0 {
 			nextState = scanStateRecursion
 			buf.ResetRecurse()
 			for _, entry := range newEntries.Recursive() {
 				buf.WriteRecurse(entry.Content)
 			}
+		} else if len(levels) > 0 && remaining > 0 {
+			// Proceed to the next delay level with all recursive content activated so far,
+			// once a pass at the current level activates nothing new
+			level, levels = levels[0], levels[1:]
+			nextState = scanStateRecursion
+			buf.ResetRecurse()
+			for _, entry := range activated.Recursive() {
+				buf.WriteRecurse(entry.Content)
+			}
+			log.Debug().Int("delay_level", level).Msg("World Info recursion delay level advanced")
 		}
 
 		newEntries = newEntries[:0]
@@ -111,6 +180,10 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 		log.Debug().Int("remaining", remaining).Msg("checkWorldInfo iteration end")
 	}
 
+	for _, entry := range activated {
+		timed.activate(entry.stateKey(), chatLength, entry.Sticky, entry.Cooldown)
+	}
+
 	if activated.Len() == 0 {
 		log.Debug().Msg("No lorebook entries activated")
 		return nil, nil
@@ -138,39 +211,7 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 			Msg("DepthPrompt activated")
 	}
 
-	topOfAuthorsNote := make(lorebookEntriesType, 0)
-	bottomOfAuthorsNote := make(lorebookEntriesType, 0)
-	for _, entry := range activated.Sort() {
-		switch entry.Position {
-		case ccv3.LorebookInsertionTopOfAuthorsNote:
-			topOfAuthorsNote.Unshift(entry)
-		case ccv3.LorebookInsertionBottomOfAuthorsNote:
-			bottomOfAuthorsNote.Unshift(entry)
-		default:
-		}
-	}
-
 	wi := worldInfoType{}
-	if topOfAuthorsNote.Len()+bottomOfAuthorsNote.Len() > 0 {
-		authorsNote := append(topOfAuthorsNote.Contents(), bottomOfAuthorsNote.Contents()...)
-		activated.Push(lorebookEntryType{
-			Name:      "AuthorsNote",
-			Content:   strings.Join(authorsNote, "\n"),
-			Role:      system,
-			Order:     1024,
-			activated: true,
-			LorebookEntryExtension: ccv3.LorebookEntryExtension{
-				Position: ccv3.LorebookInsertionAtDepth,
-				Depth:    4,
-			},
-		})
-		log.Debug().
-			Str("role", "system").
-			Int("depth", 4).
-			Int("lines", len(authorsNote)).
-			Msg("AuthorsNote activated")
-		wi.authorsNotesCount += len(authorsNote)
-	}
 	for _, entry := range activated.Sort() {
 		switch entry.Position {
 		case ccv3.LorebookInsertionBeforeCharDefs:
@@ -183,6 +224,10 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 			wi.AfterExampleMessages.Unshift(entry)
 		case ccv3.LorebookInsertionAtDepth:
 			wi.AtDepth.Unshift(entry)
+		case ccv3.LorebookInsertionTopOfAuthorsNote:
+			wi.TopOfAuthorsNote.Unshift(entry)
+		case ccv3.LorebookInsertionBottomOfAuthorsNote:
+			wi.BottomOfAuthorsNote.Unshift(entry)
 		default:
 		}
 	}
@@ -191,6 +236,138 @@ func (c *cardType) checkWorldInfo(messages []messageType) (*worldInfoType, error
 	return &wi, nil
 }
 
+// worldInfoBudgetType 记录 World Info 的 token 预算使用情况，预算包括全局预算与各设定集的 token_budget
+type worldInfoBudgetType struct {
+	counter    TokenCounter
+	process    func(string) string
+	total      int // 全局预算，0 为不限制
+	used       int
+	overflowed bool // 全局预算是否已耗尽
+
+	bookUsed       map[*lorebookType]int
+	bookOverflowed map[*lorebookType]bool
+}
+
+func (c *cardType) newWorldInfoBudget(budget *ContextBudget) *worldInfoBudgetType {
+	b := &worldInfoBudgetType{
+		counter:        tokenizer.NewEstimator(),
+		process:        c.processPrompt,
+		bookUsed:       make(map[*lorebookType]int),
+		bookOverflowed: make(map[*lorebookType]bool),
+	}
+	if budget == nil {
+		return b
+	}
+	b.counter = budget.counter()
+	if budget.WorldInfoBudget > 0 && budget.ContextSize > 0 {
+		b.total = budget.ContextSize * budget.WorldInfoBudget / 100
+	}
+	if budget.WorldInfoBudgetCap > 0 && (b.total == 0 || b.total > budget.WorldInfoBudgetCap) {
+		b.total = budget.WorldInfoBudgetCap
+	}
+	return b
+}
+
+// Admit 按常驻条目优先、其余按 Order 的顺序计入本轮激活的条目，返回预算内的条目。
+// 某个预算首次不足时即视为耗尽，之后计入该预算的条目均被跳过并记录到 report 中。
+// 设置了 ignore_budget 的条目总是被计入，其 token 仍占用预算。
+func (b *worldInfoBudgetType) Admit(entries lorebookEntriesType, report *ApplyReport) lorebookEntriesType {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Constant && !entries[j].Constant
+	})
+	admitted := make(lorebookEntriesType, 0, len(entries))
+	for _, entry := range entries {
+		tokens := b.counter.Count(b.process(entry.Content))
+		book := entry.book
+		bookBudget := 0
+		if book != nil {
+			bookBudget = book.data.TokenBudget
+		}
+		switch {
+		case entry.IgnoreBudget:
+			// 不受预算限制，直接计入
+		case b.overflowed || (b.total > 0 && b.used+tokens > b.total):
+			b.overflowed = true
+			report.SkippedEntries = append(report.SkippedEntries, entry.Name)
+			log.Debug().Str("name", entry.Name).Int("tokens", tokens).Msg("Lorebook entry skipped, token budget exhausted")
+			continue
+		case book != nil && (b.bookOverflowed[book] || (bookBudget > 0 && b.bookUsed[book]+tokens > bookBudget)):
+			b.bookOverflowed[book] = true
+			report.SkippedEntries = append(report.SkippedEntries, entry.Name)
+			log.Debug().Str("name", entry.Name).Int("tokens", tokens).Msg("Lorebook entry skipped, token budget exhausted")
+			continue
+		}
+		b.used += tokens
+		if book != nil {
+			b.bookUsed[book] += tokens
+		}
+		admitted = append(admitted, entry)
+	}
+	report.WorldInfoTokens = b.used
+	return admitted
+}
+
+// matchEntry 返回条目匹配的主关键词，条目为选择性条目时还需次关键词满足 SelectiveLogic
+func (c *cardType) matchEntry(buf *worldInfoBufferType, entry lorebookEntryType) (string, bool) {
+	primary := ""
+	for _, key := range entry.Keys {
+		if key != "" && buf.Match(key, entry) {
+			primary = key
+			break
+		}
+	}
+	if primary == "" {
+		return "", false
+	}
+	if !entry.Selective {
+		return primary, true
+	}
+
+	total, matched := 0, 0
+	for _, key := range entry.SecondaryKeys {
+		if key == "" {
+			continue
+		}
+		total++
+		if buf.Match(key, entry) {
+			matched++
+		}
+	}
+	if total == 0 {
+		return primary, true
+	}
+	var ok bool
+	switch entry.SelectiveLogic {
+	case ccv3.SelectiveLogicNotAll:
+		ok = matched < total
+	case ccv3.SelectiveLogicNotAny:
+		ok = matched == 0
+	case ccv3.SelectiveLogicAndAll:
+		ok = matched == total
+	default:
+		ok = matched > 0
+	}
+	if !ok {
+		log.Debug().Str("name", entry.Name).Int("logic", entry.SelectiveLogic).Int("matched", matched).Msg("Lorebook entry secondary keys not satisfied")
+	}
+	return primary, ok
+}
+
+// scoreEntry 返回条目的关键词命中数，AND ANY 与 AND ALL 条目同时计入次关键词
+func (c *cardType) scoreEntry(buf *worldInfoBufferType, entry lorebookEntryType) int {
+	keys := entry.Keys
+	if entry.Selective && (entry.SelectiveLogic == ccv3.SelectiveLogicAndAny || entry.SelectiveLogic == ccv3.SelectiveLogicAndAll) {
+		keys = append(keys[:len(keys):len(keys)], entry.SecondaryKeys...)
+	}
+	score := 0
+	for _, key := range keys {
+		if key != "" && buf.Match(key, entry) {
+			score++
+		}
+	}
+	return score
+}
+
 type scanStateType int
 
 const (
@@ -218,8 +395,8 @@ type worldInfoType struct {
 	AtDepth               lorebookEntriesType
 	BeforeExampleMessages lorebookEntriesType
 	AfterExampleMessages  lorebookEntriesType
-
-	authorsNotesCount int
+	TopOfAuthorsNote      lorebookEntriesType
+	BottomOfAuthorsNote   lorebookEntriesType
 }
 
 func (l *worldInfoType) Len() int {
@@ -227,38 +404,88 @@ func (l *worldInfoType) Len() int {
 }
 
 func (l *worldInfoType) ActivatedCount() int {
-	return l.Len() + l.authorsNotesCount
+	return l.Len() + l.BeforeExampleMessages.Len() + l.AfterExampleMessages.Len() +
+		l.TopOfAuthorsNote.Len() + l.BottomOfAuthorsNote.Len()
 }
 
 type lorebookEntryType struct {
-	Name     string
-	Keys     []string
-	Content  string
-	Role     roleType
-	Order    int
-	Constant bool
-
+	Name          string
+	Keys          []string
+	SecondaryKeys []string
+	Selective     bool
+	Content       string
+	Role          roleType
+	Order         int
+	Constant      bool
+	UseRegex      bool // 关键词是否为不带 /pattern/flags 包裹的正则表达式
+
+	uid        string // 条目在所属设定集中的ID
 	activated  bool
 	rollFailed bool
+	sticky     bool // 是否因 sticky 而激活
+	score      int  // 组评分时的关键词命中数
+	book       *lorebookType
 	ccv3.LorebookEntryExtension
 }
 
+// scanDepth 返回条目的扫描深度，未设置时依次继承所属设定集的扫描深度与默认值
+func (e *lorebookEntryType) scanDepth(def int) int {
+	if e.ScanDepth > 0 {
+		return e.ScanDepth
+	}
+	if e.book != nil && e.book.data.ScanDepth > 0 {
+		return e.book.data.ScanDepth
+	}
+	return def
+}
+
+// delayLevel 返回条目的 DelayUntilRecursion 等级，true 为 1，未设置或 false 为 0
+func (e *lorebookEntryType) delayLevel() int {
+	switch v := e.DelayUntilRecursion.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+	case float64:
+		return max(int(v), 0)
+	case int:
+		return max(v, 0)
+	}
+	return 0
+}
+
+// recursiveScanning 返回条目所属的设定集是否启用递归扫描
+func (e *lorebookEntryType) recursiveScanning() bool {
+	return e.book == nil || e.book.data.RecursiveScanning
+}
+
+// stateKey 返回条目在聊天状态中的键
+func (e *lorebookEntryType) stateKey() string {
+	if e.book == nil {
+		return e.uid
+	}
+	return e.book.name + "/" + e.uid
+}
+
 func newLorebookEntriesFromCCV3(entries []ccv3.LorebookEntry) (lorebookEntriesType, error) {
 	lorebookEntries := make(lorebookEntriesType, 0, len(entries))
-	for _, entry := range entries {
+	for i, entry := range entries {
 		if !entry.Enabled {
 			continue
 		}
-		if entry.Extensions.Vectorized {
-			log.Warn().Str("name", entry.Name).Msg("Vectorized lorebook entries are not supported and will be ignored")
-			continue
-		}
 		lorebookEntry := lorebookEntryType{
-			Name:     entry.Comment,
-			Keys:     entry.Keys,
-			Content:  entry.Content,
-			Order:    entry.InsertionOrder,
-			Constant: entry.Constant,
+			Name:          entry.Comment,
+			Keys:          entry.Keys,
+			SecondaryKeys: entry.SecondaryKeys,
+			Selective:     entry.Selective,
+			Content:       entry.Content,
+			Order:         entry.InsertionOrder,
+			Constant:      entry.Constant,
+			UseRegex:      entry.UseRegex,
+			uid:           strconv.Itoa(i),
+		}
+		if entry.ID != nil {
+			lorebookEntry.uid = fmt.Sprint(entry.ID)
 		}
 		switch entry.Extensions.Role {
 		case ccv3.RoleSystem:
@@ -268,9 +495,16 @@ func newLorebookEntriesFromCCV3(entries []ccv3.LorebookEntry) (lorebookEntriesTy
 		case ccv3.RoleAssistant:
 			lorebookEntry.Role = assistant
 		default:
-			return nil, fmt.Errorf("unknown role: %v", entry.Extensions.Role)
+			log.Warn().Str("name", entry.Comment).Int("role", int(entry.Extensions.Role)).Msg("Lorebook entry has an unknown role and will be ignored")
+			continue
+		}
+		if p := entry.Extensions.Position; p < ccv3.LorebookInsertionBeforeCharDefs || p > ccv3.LorebookInsertionAfterExampleMessages {
+			log.Warn().Str("name", entry.Comment).Int("position", int(p)).Msg("Lorebook entry has an unsupported position and will be ignored")
+			continue
 		}
 		lorebookEntry.LorebookEntryExtension = entry.Extensions
+		lorebookEntry.Probability = min(max(lorebookEntry.Probability, 0), 100)
+		lorebookEntry.Depth = max(lorebookEntry.Depth, 0)
 		lorebookEntries = append(lorebookEntries, lorebookEntry)
 	}
 	return lorebookEntries, nil
@@ -286,11 +520,26 @@ func (le *lorebookEntriesType) Copy() lorebookEntriesType {
 	copy(copied, *le)
 	return copied
 }
+
+// ExpandMacros 在扫描前一次性替换所有条目关键词中的宏
+func (le *lorebookEntriesType) ExpandMacros(macros *caseInsensitiveReplacer) {
+	expand := func(keys []string) []string {
+		expanded := make([]string, len(keys))
+		for i, key := range keys {
+			expanded[i] = macros.Replace(key)
+		}
+		return expanded
+	}
+	for i := range *le {
+		(*le)[i].Keys = expand((*le)[i].Keys)
+		(*le)[i].SecondaryKeys = expand((*le)[i].SecondaryKeys)
+	}
+}
 func (le *lorebookEntriesType) Sort() lorebookEntriesType {
 	sorted := make(lorebookEntriesType, len(*le))
 	copy(sorted, *le)
-	// Sort by Order (descending)
-	sort.Slice(sorted, func(i, j int) bool {
+	// Sort by Order (descending), entries with the same Order keep their lorebook order
+	sort.SliceStable(sorted, func(i, j int) bool {
 		return sorted[i].Order > sorted[j].Order
 	})
 	*le = sorted
@@ -332,10 +581,41 @@ func (le *lorebookEntriesType) Depth(min, max int) lorebookEntriesType {
 	}
 	return filtered
 }
+func (le *lorebookEntriesType) Trigger(generationType string) lorebookEntriesType {
+	filtered := make(lorebookEntriesType, 0, len(*le))
+	for _, entry := range *le {
+		triggers := entry.triggers()
+		if len(triggers) == 0 || slices.Contains(triggers, generationType) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// triggers 返回条目中有效的生成类型，忽略非字符串的值
+func (e *lorebookEntryType) triggers() []string {
+	triggers := make([]string, 0, len(e.Triggers))
+	for _, trigger := range e.Triggers {
+		if t, ok := trigger.(string); ok {
+			triggers = append(triggers, t)
+		}
+	}
+	return triggers
+}
+func (le *lorebookEntriesType) DelayLevels() []int {
+	levels := make([]int, 0)
+	for _, entry := range *le {
+		if level := entry.delayLevel(); level > 0 && !slices.Contains(levels, level) {
+			levels = append(levels, level)
+		}
+	}
+	slices.Sort(levels)
+	return levels
+}
 func (le *lorebookEntriesType) Recursive() lorebookEntriesType {
 	filtered := make(lorebookEntriesType, 0, len(*le))
 	for _, entry := range *le {
-		if !entry.PreventRecursion {
+		if !entry.PreventRecursion && entry.recursiveScanning() {
 			filtered = append(filtered, entry)
 		}
 	}
@@ -344,8 +624,8 @@ func (le *lorebookEntriesType) Recursive() lorebookEntriesType {
 
 func (c *cardType) buildWorldInfoBuffer(messages []messageType) worldInfoBufferType {
 	w := worldInfoBufferType{
-		Data:        c.data,
-		UserPersona: c.UserPersona,
+		Sources:   c.buildMatchSources(),
+		ScanDepth: c.WorldInfoScanDepth,
 	}
 	w.WriteDepth(messages)
 	return w
@@ -354,8 +634,8 @@ func (c *cardType) buildWorldInfoBuffer(messages []messageType) worldInfoBufferT
 const worldInfoDelim = "\x01\n"
 
 type worldInfoBufferType struct {
-	Data        ccv3.CharacterCardData
-	UserPersona string
+	Sources   []matchSourceText
+	ScanDepth int // 条目与设定集均未设置时的扫描深度
 
 	haystackBuffer bytes.Buffer
 	depthBuffer    []string
@@ -363,48 +643,24 @@ type worldInfoBufferType struct {
 }
 
 func (w *worldInfoBufferType) Load(e lorebookEntryType) int {
-	if e.ScanDepth < 0 {
-		log.Error().Msg(fmt.Sprintf("invalid entry %v", e))
-		return 0
-	}
 	w.haystackBuffer.Reset()
 
-	if e.ScanDepth <= 0 {
-		e.ScanDepth = 2
-	}
-
-	for _, msg := range w.depthBuffer[:e.ScanDepth] {
+	depth := min(e.scanDepth(w.ScanDepth), len(w.depthBuffer))
+	for _, msg := range w.depthBuffer[:depth] {
 		w.haystackBuffer.WriteString(worldInfoDelim)
 		w.haystackBuffer.WriteString(msg)
 	}
 
-	if e.MatchPersonaDescription {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.UserPersona)
-	}
-	if e.MatchCharacterDescription {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.Data.Description)
-	}
-	if e.MatchCharacterPersonality {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.Data.Personality)
-	}
-	if e.MatchCharacterDepthPrompt {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.Data.Extensions.DepthPrompt.Prompt)
-	}
-	if e.MatchScenario {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.Data.Scenario)
-	}
-	if e.MatchCreatorNotes {
-		w.haystackBuffer.WriteString(worldInfoDelim)
-		w.haystackBuffer.WriteString(w.Data.CreatorNotes)
+	for _, source := range w.Sources {
+		if source.enabledFor(e) {
+			w.haystackBuffer.WriteString(worldInfoDelim)
+			w.haystackBuffer.WriteString(source.text)
+		}
 	}
 	if w.recurseBuffer.Len() > 0 {
 		w.haystackBuffer.WriteString(worldInfoDelim)
-		_, _ = w.recurseBuffer.WriteTo(&w.haystackBuffer)
+		// Copy instead of WriteTo, which would drain the recursion buffer after the first entry
+		w.haystackBuffer.Write(w.recurseBuffer.Bytes())
 	}
 
 	return w.haystackBuffer.Len()
@@ -415,9 +671,19 @@ func (w *worldInfoBufferType) Len() int {
 }
 
 func (w *worldInfoBufferType) Match(needle string, e lorebookEntryType) bool {
-	re, err := w.parseRegex(needle)
+	re, err := ccv3.ParseKeyRegex(needle)
 	if err == nil {
-		return re.MatchString(needle)
+		return re.MatchString(w.haystackBuffer.String())
+	}
+	if e.UseRegex {
+		pattern := needle
+		if e.CaseSensitive == nil || !*e.CaseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		if re, err := regexp.Compile(pattern); err == nil {
+			return re.MatchString(w.haystackBuffer.String())
+		}
+		log.Debug().Str("name", e.Name).Str("key", needle).Msg("Invalid regex key, matching as plain text")
 	}
 
 	// Fallback to substring match
@@ -469,35 +735,6 @@ func (w *worldInfoBufferType) Reset() {
 }
 
 var reKeywords = regexp.MustCompile(`\s+`)
-var reRegex = regexp.MustCompile(`^/([\w\W]+?)/([gimsuy]*)$`)
-var reUnescapedSlash = regexp.MustCompile(`(^|[^\\])/`)
-
-func (w *worldInfoBufferType) parseRegex(pattern string) (*regexp.Regexp, error) {
-	matches := reRegex.FindStringSubmatch(pattern)
-	if len(matches) != 3 {
-		return nil, fmt.Errorf("invalid regex pattern: %s", pattern)
-	}
-	re := matches[1]
-	flags := matches[2]
-	if flags != "" {
-		flagStr := ""
-		if strings.Contains(flags, "i") {
-			flagStr = flagStr + "i"
-		}
-		if strings.Contains(flags, "m") {
-			flagStr = flagStr + "m"
-		}
-		if strings.Contains(flags, "s") {
-			flagStr = flagStr + "s"
-		}
-		re = "(?" + flagStr + ")" + re
-	}
-	if reUnescapedSlash.MatchString(re) {
-		return nil, fmt.Errorf("unescaped slash in regex pattern: %s", pattern)
-	}
-	re = strings.ReplaceAll(re, "\\/", "/")
-	return regexp.Compile(re)
-}
 
 func roll(probability int) bool {
 	if probability <= 0 {
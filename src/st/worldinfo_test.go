@@ -0,0 +1,351 @@
+package st
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwindy/xitu/st/ccv3"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// newTestCard 返回带有给定设定集条目的角色卡，条目以 Comment 作为名称
+func newTestCard(t *testing.T, data ccv3.CharacterCardData, entries ...ccv3.LorebookEntry) *cardType {
+	t.Helper()
+	if data.Name == "" {
+		data.Name = "Alice"
+	}
+	if len(entries) > 0 {
+		data.CharacterBook = &ccv3.Lorebook{RecursiveScanning: true, Entries: entries}
+	}
+	raw, err := json.Marshal(ccv3.CharacterCard{Spec: ccv3.SpecV3, SpecVersion: ccv3.SpecVersionV3, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := NewCard(raw)
+	if err != nil {
+		t.Fatalf("NewCard() error = %v", err)
+	}
+	return card.(*cardType)
+}
+
+// testEntry 返回一个启用的、插入到角色定义之前的条目
+func testEntry(name string, keys ...string) ccv3.LorebookEntry {
+	return ccv3.LorebookEntry{
+		Comment: name,
+		Keys:    keys,
+		Content: name + " content",
+		Enabled: true,
+	}
+}
+
+// testChat 返回以用户消息结尾、用户与助手交替的聊天记录
+func testChat(contents ...string) []messageType {
+	messages := make([]messageType, 0, len(contents))
+	for i, content := range contents {
+		role := user
+		if (len(contents)-1-i)%2 == 1 {
+			role = assistant
+		}
+		messages = append(messages, messageType{Role: role, Content: content})
+	}
+	return messages
+}
+
+// activatedNames 返回激活的条目名称，按名称排序
+func activatedNames(t *testing.T, c *cardType, messages []messageType, opts ApplyOptions) []string {
+	t.Helper()
+	if opts.Report == nil {
+		opts.Report = &ApplyReport{}
+	}
+	wi, err := c.checkWorldInfo(messages, c.lorebook, opts)
+	if err != nil {
+		t.Fatalf("checkWorldInfo() error = %v", err)
+	}
+	names := make([]string, 0)
+	if wi == nil {
+		return names
+	}
+	for _, entries := range []lorebookEntriesType{
+		wi.BeforeCharDefs, wi.AfterCharDefs, wi.AtDepth, wi.BeforeExampleMessages,
+		wi.AfterExampleMessages, wi.TopOfAuthorsNote, wi.BottomOfAuthorsNote,
+	} {
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestSelectiveLogic(t *testing.T) {
+	tests := []struct {
+		name  string
+		logic int
+		text  string
+		want  bool
+	}{
+		{"and any, none", ccv3.SelectiveLogicAndAny, "a dragon", false},
+		{"and any, one", ccv3.SelectiveLogicAndAny, "a dragon of fire", true},
+		{"and all, one", ccv3.SelectiveLogicAndAll, "a dragon of fire", false},
+		{"and all, all", ccv3.SelectiveLogicAndAll, "a dragon of fire and ice", true},
+		{"not any, none", ccv3.SelectiveLogicNotAny, "a dragon", true},
+		{"not any, one", ccv3.SelectiveLogicNotAny, "a dragon of ice", false},
+		{"not all, one", ccv3.SelectiveLogicNotAll, "a dragon of ice", true},
+		{"not all, all", ccv3.SelectiveLogicNotAll, "a dragon of fire and ice", false},
+		{"unknown logic is and any", 9, "a dragon of ice", true},
+		{"primary key missing", ccv3.SelectiveLogicNotAny, "fire and ice", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := testEntry("dragon", "dragon")
+			entry.Selective = true
+			entry.SecondaryKeys = []string{"fire", "ice"}
+			entry.Extensions.SelectiveLogic = tt.logic
+			c := newTestCard(t, ccv3.CharacterCardData{}, entry)
+
+			got := len(activatedNames(t, c, testChat(tt.text), ApplyOptions{})) > 0
+			if got != tt.want {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyMatching(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name          string
+		key           string
+		useRegex      bool
+		caseSensitive *bool
+		wholeWords    *bool
+		text          string
+		want          bool
+	}{
+		{"plain", "dragon", false, nil, nil, "The Dragon sleeps", true},
+		{"case sensitive", "dragon", false, &yes, nil, "The Dragon sleeps", false},
+		{"whole words", "drag", false, nil, nil, "The dragon sleeps", false},
+		{"partial words", "drag", false, nil, &no, "The dragon sleeps", true},
+		{"non-ascii substring", "龙", false, nil, nil, "一条巨龙", true},
+		{"slash regex", "/dra+gon/i", false, nil, nil, "DRAAGON", true},
+		{"use_regex", "dra+gon", true, nil, nil, "The Draaagon", true},
+		{"use_regex case sensitive", "dra+gon", true, &yes, nil, "The Draaagon", false},
+		{"use_regex disabled", "dra+gon", false, nil, nil, "The draaagon", false},
+		{"invalid use_regex is plain text", "dragon(", true, nil, &no, "a dragon( appears", true},
+		{"macro", "{{char}}", false, nil, nil, "hello alice", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := testEntry("entry", tt.key)
+			entry.UseRegex = tt.useRegex
+			entry.Extensions.CaseSensitive = tt.caseSensitive
+			entry.Extensions.MatchWholeWords = tt.wholeWords
+			c := newTestCard(t, ccv3.CharacterCardData{}, entry)
+			c.initDefaultSettings()
+
+			got := len(activatedNames(t, c, testChat(tt.text), ApplyOptions{})) > 0
+			if got != tt.want {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchSources(t *testing.T) {
+	data := ccv3.CharacterCardData{
+		Description:  "She owns a griffin.",
+		Personality:  "Brave like a lion.",
+		Scenario:     "A storm over the harbor.",
+		CreatorNotes: "Made for {{user}}.",
+		Extensions:   ccv3.CharacterCardExtension{DepthPrompt: ccv3.CharacterCardDepthPrompt{Prompt: "Mention the comet.", Role: "system"}},
+	}
+	tests := []struct {
+		source string
+		key    string
+		enable func(ext *ccv3.LorebookEntryExtension)
+	}{
+		{MatchSourcePersona, "wizard", func(ext *ccv3.LorebookEntryExtension) { ext.MatchPersonaDescription = true }},
+		{MatchSourceDescription, "griffin", func(ext *ccv3.LorebookEntryExtension) { ext.MatchCharacterDescription = true }},
+		{MatchSourcePersonality, "lion", func(ext *ccv3.LorebookEntryExtension) { ext.MatchCharacterPersonality = true }},
+		{MatchSourceDepthPrompt, "comet", func(ext *ccv3.LorebookEntryExtension) { ext.MatchCharacterDepthPrompt = true }},
+		{MatchSourceScenario, "harbor", func(ext *ccv3.LorebookEntryExtension) { ext.MatchScenario = true }},
+		{MatchSourceCreatorNotes, "bob", func(ext *ccv3.LorebookEntryExtension) { ext.MatchCreatorNotes = true }},
+	}
+	for _, tt := range tests {
+		for _, mode := range []string{"off", "entry", "global"} {
+			t.Run(tt.source+"/"+mode, func(t *testing.T) {
+				entry := testEntry("entry", tt.key)
+				if mode == "entry" {
+					tt.enable(&entry.Extensions)
+				}
+				// Enabling another source must not add this one's text
+				other := testEntry("other", tt.key)
+				for _, o := range tests {
+					if o.source != tt.source {
+						o.enable(&other.Extensions)
+					}
+				}
+				c := newTestCard(t, data, entry, other)
+				c.UserName = "Bob"
+				c.UserPersona = "A wizard from the north."
+				if mode == "global" {
+					c.WorldInfoMatchSources = []string{tt.source}
+				}
+
+				got := activatedNames(t, c, testChat("nothing here"), ApplyOptions{})
+				got = slices.DeleteFunc(got, func(name string) bool { return name == "DepthPrompt" })
+				want := []string{"entry"}
+				if mode == "off" {
+					want = []string{}
+				}
+				if mode == "global" {
+					want = []string{"entry", "other"}
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("activated = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCustomMatchSource(t *testing.T) {
+	tags := MatchSource{
+		Name: "tags",
+		Text: func(ctx MatchContext) string { return strings.Join(ctx.Data.Tags, ", ") },
+	}
+	tests := []struct {
+		name   string
+		global []string
+		want   []string
+	}{
+		{"not enabled", nil, []string{}},
+		{"enabled globally", []string{"tags"}, []string{"entry"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{Tags: []string{"fantasy", "dragons"}}, testEntry("entry", "dragons"))
+			c.MatchSources = append(DefaultMatchSources(), tags)
+			c.WorldInfoMatchSources = tt.global
+			if got := activatedNames(t, c, testChat("nothing here"), ApplyOptions{}); !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	first := testEntry("first", "castle")
+	first.Content = "The castle has a dungeon."
+	second := testEntry("second", "dungeon")
+	second.Content = "The dungeon hides a relic."
+	third := testEntry("third", "relic")
+	excluded := testEntry("excluded", "dungeon")
+	excluded.Extensions.ExcludeRecursion = true
+	delayed := testEntry("delayed", "castle")
+	delayed.Extensions.DelayUntilRecursion = true
+
+	tests := []struct {
+		name       string
+		maxSteps   int
+		recursive  bool
+		preventRec bool
+		want       []string
+	}{
+		{"unlimited", 0, true, false, []string{"delayed", "first", "second", "third"}},
+		{"one recursion step", 1, true, false, []string{"first", "second"}},
+		{"prevent recursion", 0, true, true, []string{"delayed", "first"}},
+		{"book disables recursion", 0, false, false, []string{"first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := first
+			first.Extensions.PreventRecursion = tt.preventRec
+			c := newTestCard(t, ccv3.CharacterCardData{}, first, second, third, excluded, delayed)
+			c.WorldInfoMaxRecursionSteps = tt.maxSteps
+			for i := range c.lorebook {
+				c.lorebook[i].book.data.RecursiveScanning = tt.recursive
+			}
+
+			got := activatedNames(t, c, testChat("we reach the castle"), ApplyOptions{})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayLevels(t *testing.T) {
+	delayed := func(name string, level any, keys ...string) ccv3.LorebookEntry {
+		entry := testEntry(name, keys...)
+		entry.Extensions.DelayUntilRecursion = level
+		return entry
+	}
+	plain := testEntry("plain", "castle")
+	plain.Content = "A gate."
+	level1 := delayed("level1", 1, "castle")
+	level1.Content = "A tower."
+	level2 := delayed("level2", 2, "castle")
+	fromLevel1 := delayed("fromLevel1", 2, "tower")
+
+	tests := []struct {
+		name     string
+		entries  []ccv3.LorebookEntry
+		maxSteps int
+		want     []string
+	}{
+		{"only delayed entry", []ccv3.LorebookEntry{delayed("delayed", true, "castle")}, 0, []string{"delayed"}},
+		{"delayed entry without recursive matches", []ccv3.LorebookEntry{delayed("delayed", true, "castle"), testEntry("other", "dragon")}, 0, []string{"delayed"}},
+		{"numeric levels", []ccv3.LorebookEntry{plain, level1, level2, fromLevel1}, 0, []string{"fromLevel1", "level1", "level2", "plain"}},
+		{"levels are separate steps", []ccv3.LorebookEntry{plain, level1, level2, fromLevel1}, 2, []string{"level1", "plain"}},
+		{"second level step", []ccv3.LorebookEntry{plain, level1, level2, fromLevel1}, 4, []string{"fromLevel1", "level1", "level2", "plain"}},
+		{"unmatched level", []ccv3.LorebookEntry{delayed("level3", 3, "dragon"), level1}, 0, []string{"level1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{}, tt.entries...)
+			c.WorldInfoMaxRecursionSteps = tt.maxSteps
+			got := activatedNames(t, c, testChat("we reach the castle"), ApplyOptions{})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	always := testEntry("always", "dragon")
+	normal := testEntry("normal", "dragon")
+	normal.Extensions.Triggers = []any{ccv3.TriggerNormal}
+	continued := testEntry("continue", "dragon")
+	continued.Extensions.Triggers = []any{ccv3.TriggerContinue, 1}
+	invalid := testEntry("invalid", "dragon")
+	invalid.Extensions.Triggers = []any{1}
+
+	tests := []struct {
+		generationType string
+		want           []string
+	}{
+		{"", []string{"always", "invalid", "normal"}},
+		{ccv3.TriggerNormal, []string{"always", "invalid", "normal"}},
+		{ccv3.TriggerContinue, []string{"always", "continue", "invalid"}},
+		{ccv3.TriggerQuiet, []string{"always", "invalid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.generationType, func(t *testing.T) {
+			c := newTestCard(t, ccv3.CharacterCardData{}, always, normal, continued, invalid)
+			got := activatedNames(t, c, testChat("a dragon"), ApplyOptions{GenerationType: tt.generationType})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("activated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
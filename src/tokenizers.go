@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cloudwindy/xitu/st/tokenizer"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	tokenizerCache   = make(map[string]tokenizer.Tokenizer)
+	tokenizerCacheMu sync.Mutex
+)
+
+// loadTokenizer 返回指定名称的分词器，BPE 词表从 tokenizers/<name>.tiktoken 读取
+func loadTokenizer(name string) (tokenizer.Tokenizer, error) {
+	if name == "" || name == tokenizer.EstimatorName {
+		return tokenizer.NewEstimator(), nil
+	}
+	filePath := fmt.Sprintf("tokenizers/%s.tiktoken", name)
+
+	t, err := tokenizer.LoadBPE(name, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokenizer %s: %w", name, err)
+	}
+	log.Info().Str("tokenizer", name).Msg("Tokenizer loaded")
+
+	return t, nil
+}
+
+// modelTokenizer 返回上游模型使用的分词器，无法加载时使用估算器
+func modelTokenizer(model string) tokenizer.Tokenizer {
+	name := config.Model(model).Tokenizer
+
+	tokenizerCacheMu.Lock()
+	defer tokenizerCacheMu.Unlock()
+	if t, ok := tokenizerCache[name]; ok {
+		return t
+	}
+	t, err := loadTokenizer(name)
+	if err != nil {
+		log.Warn().Err(err).Str("model", model).Msg("Failed to load tokenizer, falling back to estimate")
+		t = tokenizer.NewEstimator()
+	}
+	tokenizerCache[name] = t
+	return t
+}